@@ -1,6 +1,7 @@
 package chat
 
 import (
+	"context"
 	"strings"
 	"xlab-feishu-robot/internal/model"
 
@@ -9,16 +10,16 @@ import (
 
 var groupMessageMap = make(map[string]messageHandler)
 
-func group(messageevent *model.MessageEvent) {
+func group(ctx context.Context, messageevent *model.MessageEvent) {
 	switch strings.ToUpper(messageevent.Message.Message_type) {
 	case "TEXT":
-		groupTextMessage(messageevent)
+		groupTextMessage(ctx, messageevent)
 	default:
-		logrus.WithFields(logrus.Fields{"message type": messageevent.Message.Message_type}).Warn("Receive group message, but this type is not supported")
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"message type": messageevent.Message.Message_type}).Warn("Receive group message, but this type is not supported")
 	}
 }
 
-func groupTextMessage(messageevent *model.MessageEvent) {
+func groupTextMessage(ctx context.Context, messageevent *model.MessageEvent) {
 	// If the robot is triggered by accident, return
 	if isAccident(messageevent) {
 		return
@@ -27,9 +28,19 @@ func groupTextMessage(messageevent *model.MessageEvent) {
 	messageevent.Message.Content = strings.TrimSuffix(strings.TrimPrefix(messageevent.Message.Content, "{\"text\":\""), "\"}")
 	// Get valid message content
 	messageevent.Message.Content = messageevent.Message.Content[strings.Index(messageevent.Message.Content, " ")+1:]
-	logrus.WithFields(logrus.Fields{"message content": messageevent.Message.Content}).Info("Receive group TEXT message")
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"message content": messageevent.Message.Content}).Info("Receive group TEXT message")
 
-	groupMessageMap["drawlots"](messageevent)
+	// The first word of the message is the command
+	fields := strings.Fields(messageevent.Message.Content)
+	if len(fields) == 0 {
+		return
+	}
+	handler, exists := groupMessageMap[fields[0]]
+	if !exists {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"command": fields[0]}).Warn("Receive group command, but no handler is registered")
+		return
+	}
+	handler(ctx, messageevent)
 }
 
 func GroupMessageRegister(f messageHandler, s string) {
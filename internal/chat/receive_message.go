@@ -1,27 +1,22 @@
 package chat
 
 import (
-	"encoding/json"
+	"context"
+	"xlab-feishu-robot/internal/dispatcher"
 	"xlab-feishu-robot/internal/model"
 
 	"github.com/sirupsen/logrus"
 )
 
-type messageHandler func(event *model.MessageEvent)
+type messageHandler func(ctx context.Context, event *model.MessageEvent)
 
 // dispatch message, according to Chat type
-func Receive(event map[string]any) {
-	messageevent := model.MessageEvent{}
-	map2struct(event, &messageevent)
+func Receive(ctx context.Context, event dispatcher.Event, messageevent *model.MessageEvent) error {
 	switch messageevent.Message.Chat_type {
 	case "group":
-		group(&messageevent)
+		group(ctx, messageevent)
 	default:
-		logrus.WithFields(logrus.Fields{"chat type": messageevent.Message.Chat_type}).Warn("Receive message, but this chat type is not supported")
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"chat type": messageevent.Message.Chat_type}).Warn("Receive message, but this chat type is not supported")
 	}
-}
-
-func map2struct(m map[string]interface{}, stru interface{}) {
-	bytes, _ := json.Marshal(m)
-	json.Unmarshal(bytes, stru)
+	return nil
 }
@@ -1,15 +1,78 @@
 package config
 
 import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
 	"github.com/YasyaKarasu/feishuapi"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 )
 
+// 运行模式
+const (
+	ModeNormal = "normal"
+	// dry-run模式下只读取飞书数据，所有消息只记录在日志和outbox中，不真正发送
+	ModeDryRun = "dry-run"
+)
+
+// 接收飞书事件的方式
+const (
+	TransportHTTP = "http"
+	// 通过长连接接收事件，不需要公网可访问的回调地址
+	TransportWebSocket = "websocket"
+)
+
+// 日志格式
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
 type Config struct {
+	Mode   string
 	Feishu feishuapi.Config
+	// 日志输出
+	Log struct {
+		// text 或 json
+		Format string
+		// trace, debug, info, warn, error
+		Level string
+		Dir   string
+		// 单个日志文件的大小上限（MB），超过后轮转
+		MaxSize int
+		// 轮转后的日志文件最多保留的天数和个数，0为不限制
+		MaxAge     int
+		MaxBackups int
+		Compress   bool
+	}
+
 	Server struct {
 		Port int
+		// 收到SIGTERM/SIGINT后等待请求、定时任务和事件处理结束的最长时间
+		ShutdownTimeout time.Duration
+	}
+
+	// 事件处理的工作池
+	Dispatcher struct {
+		// 接收事件的方式，http 或 websocket
+		Transport string
+		// 长连接的开放平台域名，为空时使用飞书，Lark 需填 https://open.larksuite.com
+		Domain         string
+		Workers        int
+		QueueSize      int
+		HandlerTimeout time.Duration
+		// 配置了encryptKey时，请求头中的时间戳与当前时间相差超过该值的请求被拒绝
+		RequestWindow time.Duration
+		// 请求体的大小上限，单位字节
+		MaxBodySize int64
+	}
+
+	// 管理接口 /api/admin 的访问令牌，为空时禁用管理接口
+	Admin struct {
+		Token string
 	}
 
 	// add your configuration fields here
@@ -21,25 +84,169 @@ type Config struct {
 	}
 
 	WhiteList []string
+
+	// 成员对提醒消息回复该表情后，days天内的提醒不再@该成员，emoji为空时关闭
+	Ack struct {
+		Emoji string
+		Days  int
+	}
+
+	// 每月1日的提醒文案，为空时使用默认文案
+	Remind struct {
+		// 私聊负责人的提醒
+		PersonInCharge string
+		// 群内提醒成员开始写文档
+		GroupMembers string
+	}
+
+	// 新成员进群时私聊发送的欢迎消息，welcome为空时使用默认文案
+	Onboarding struct {
+		Enabled bool
+		Welcome string
+	}
+
+	Schedule struct {
+		// 启动时补执行停机期间错过的任务，只补执行计划时间在该时间窗口内的任务
+		CatchUpWindow time.Duration
+		// 定时任务使用的时区，如 Asia/Shanghai，为空时使用系统时区
+		Timezone string
+		// 覆盖任务的默认cron表达式，任务名到表达式的映射
+		Jobs map[string]string
+	}
+
+	// 多副本部署时只有持有锁的副本执行定时任务，所有副本都处理飞书事件
+	Lock struct {
+		// none 或 file
+		Type string
+		File string
+		TTL  time.Duration
+	}
+
+	// 本地持久化数据（任务执行记录等）的存放目录
+	Store struct {
+		Dir string
+	}
+
+	// 发送消息的限流与重试，未发出的消息保存在store中，重启后继续发送
+	Sender struct {
+		// 全局每秒最多发送的消息数及突发上限
+		Rate  float64
+		Burst int
+		// 每个会话（群或用户）每秒最多发送的消息数及突发上限
+		ChatRate  float64
+		ChatBurst int
+		// 每条消息最多尝试发送的次数，重试间隔从retryBackoff开始逐次翻倍
+		MaxAttempts  int
+		RetryBackoff time.Duration
+	}
+
+	// 月度汇总文档的存放位置，未配置知识空间时写入本地Markdown文件
+	Archive struct {
+		SpaceID         string
+		ParentNodeToken string
+		URLPrefix       string
+		Dir             string
+	}
 }
 
-var C Config
+// current 当前生效的配置，热加载时整体替换
+var current atomic.Pointer[Config]
+
+func init() {
+	current.Store(&Config{})
+}
+
+// C returns the config in effect. The returned value must not be modified;
+// when the config file changes a new value replaces it as a whole
+func C() *Config {
+	return current.Load()
+}
 
-func ReadConfig() {
-	viper.SetConfigName("config")
-	viper.AddConfigPath("./config/")
+// DefaultPath 未通过--config指定时的配置文件路径
+const DefaultPath = "./config/config.yaml"
+
+// envOverrides 可以通过环境变量覆盖的配置项，用于在部署时注入密钥等，而不写入配置文件
+var envOverrides = map[string]string{
+	"feishu.appId":             "FEISHU_APP_ID",
+	"feishu.appSecret":         "FEISHU_APP_SECRET",
+	"feishu.verificationToken": "FEISHU_VERIFICATION_TOKEN",
+	"feishu.encryptKey":        "FEISHU_ENCRYPT_KEY",
+	"mode":                     "BOT_MODE",
+	"server.port":              "BOT_PORT",
+	"admin.token":              "BOT_ADMIN_TOKEN",
+	"Info.GroupID":             "BOT_GROUP_ID",
+	"Info.NodeToken":           "BOT_NODE_TOKEN",
+	"Info.PersonInChargeID":    "BOT_PERSON_IN_CHARGE_ID",
+	"Info.KnowledgeTreeURL":    "BOT_KNOWLEDGE_TREE_URL",
+}
+
+// ReadConfig reads the config file at path, applies the environment variable overrides and validates the result.
+// Every invalid field is logged and the robot exits if any is found
+func ReadConfig(path string) {
+	viper.SetConfigFile(path)
+	for key, env := range envOverrides {
+		viper.BindEnv(key, env)
+	}
+	bindSecretFileEnvs()
 
 	if err := viper.ReadInConfig(); err != nil {
 		logrus.Panic(err)
 	}
 
-	if err := viper.Unmarshal(&C); err != nil {
-		logrus.Error("Failed to unmarshal config")
+	c, err := load()
+	if err != nil {
+		logInvalid(err)
+		logrus.Fatalf("Config file %s is invalid", path)
+	}
+	current.Store(c)
+
+	logrus.Info("Configuration file loaded: ", path)
+	if c.DryRun() {
+		logrus.Warn("Running in dry-run mode, messages will not be sent")
 	}
+}
+
+// load unmarshals the config viper has read, reads the secret files and validates the result
+func load() (*Config, error) {
+	var c Config
+	if err := viper.Unmarshal(&c); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
+	}
+	var problems ValidationError
+	if err := readSecretFiles(&c); err != nil {
+		problems = problems.add(err)
+	}
+	if err := c.Validate(); err != nil {
+		problems = problems.add(err)
+	}
+	if len(problems) > 0 {
+		return nil, problems
+	}
+	return &c, nil
+}
+
+// logInvalid logs every problem of a config which failed to load
+func logInvalid(err error) {
+	var invalid ValidationError
+	if !errors.As(err, &invalid) {
+		logrus.Error("Invalid config: ", err)
+		return
+	}
+	for _, problem := range invalid {
+		logrus.Error("Invalid config: ", problem)
+	}
+}
+
+// DryRun reports whether the bot runs in dry-run mode
+func (c Config) DryRun() bool {
+	return c.Mode == ModeDryRun
+}
 
-	logrus.Info("Configuration file loaded")
+// LongConnection reports whether feishu events are received over the long connection instead of http callbacks
+func (c Config) LongConnection() bool {
+	return c.Dispatcher.Transport == TransportWebSocket
 }
 
 func SetupFeishuApiClient(cli *feishuapi.AppClient) {
-	cli.Conf = C.Feishu
+	cli.Conf = C().Feishu
 }
@@ -0,0 +1,209 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// reloadDelay 配置文件最后一次变化后等待的时间
+const reloadDelay = 500 * time.Millisecond
+
+// restartOnly 修改后需要重启才能生效的配置项（前缀），与keepRestartOnly保持一致
+var restartOnly = []string{
+	"Feishu.AppId", "Feishu.AppSecret",
+	"Server.", "Dispatcher.", "Log.Dir", "Log.Max", "Log.Compress", "Lock.", "Store.", "Sender.",
+}
+
+// keepRestartOnly copies the restart-only settings of old into c, so that config.C() keeps reporting
+// the values the running process actually uses until it is restarted
+func keepRestartOnly(old *Config, c *Config) {
+	c.Feishu.AppId, c.Feishu.AppSecret = old.Feishu.AppId, old.Feishu.AppSecret
+	c.Server = old.Server
+	c.Dispatcher = old.Dispatcher
+	c.Log.Dir = old.Log.Dir
+	c.Log.MaxSize, c.Log.MaxAge, c.Log.MaxBackups = old.Log.MaxSize, old.Log.MaxAge, old.Log.MaxBackups
+	c.Log.Compress = old.Log.Compress
+	c.Lock = old.Lock
+	c.Store = old.Store
+	c.Sender = old.Sender
+}
+
+// lastReload 最近一次热加载的结果，被拒绝时err为原因，用于就绪检查
+var lastReload struct {
+	sync.Mutex
+	err error
+}
+
+// ReloadError returns why the last change of the config file was rejected,
+// or nil if it was loaded or the file has not changed since startup
+func ReloadError() error {
+	lastReload.Lock()
+	defer lastReload.Unlock()
+	return lastReload.err
+}
+
+func setReloadError(err error) {
+	lastReload.Lock()
+	defer lastReload.Unlock()
+	lastReload.err = err
+}
+
+var listeners struct {
+	sync.Mutex
+	funcs []func(old *Config, new *Config)
+}
+
+// OnChange registers f to be called after a changed config file has been loaded and swapped in
+func OnChange(f func(old *Config, new *Config)) {
+	listeners.Lock()
+	defer listeners.Unlock()
+	listeners.funcs = append(listeners.funcs, f)
+}
+
+// WatchConfig reloads the config file whenever it changes. A valid config replaces the current one
+// and the changes are logged; an invalid one is rejected and the current config is kept
+func WatchConfig() {
+	var mu sync.Mutex
+	var timer *time.Timer
+	viper.OnConfigChange(func(e fsnotify.Event) {
+		// 编辑器保存文件时可能先清空再写入，等文件稳定后再读取
+		mu.Lock()
+		defer mu.Unlock()
+		if timer != nil {
+			timer.Stop()
+		}
+		timer = time.AfterFunc(reloadDelay, func() {
+			mu.Lock()
+			defer mu.Unlock()
+			reload(e.Name)
+		})
+	})
+	viper.WatchConfig()
+	logrus.Info("Watching config file for changes")
+}
+
+func reload(path string) {
+	if err := viper.ReadInConfig(); err != nil {
+		logrus.Error("Failed to read changed config file, keeping the current config: ", err)
+		setReloadError(err)
+		return
+	}
+	c, err := load()
+	setReloadError(err)
+	if err != nil {
+		logInvalid(err)
+		logrus.Error("Rejected config change in ", path, ", keeping the current config")
+		return
+	}
+
+	old := current.Load()
+	changes := diff(old, c)
+	if len(changes) == 0 {
+		return
+	}
+	keepRestartOnly(old, c)
+	current.Store(c)
+
+	for _, change := range changes {
+		entry := logrus.WithField("change", change.String())
+		if change.restartOnly() {
+			entry.Warn("Config changed, takes effect after restart")
+		} else {
+			entry.Info("Config changed")
+		}
+	}
+
+	listeners.Lock()
+	funcs := listeners.funcs
+	listeners.Unlock()
+	for _, f := range funcs {
+		f(old, c)
+	}
+	logrus.WithField("changes", len(changes)).Info("Config reloaded from ", path)
+}
+
+// Change 一项配置的变化
+type Change struct {
+	Field string
+	Old   string
+	New   string
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s: %s -> %s", c.Field, c.Old, c.New)
+}
+
+func (c Change) restartOnly() bool {
+	for _, prefix := range restartOnly {
+		if strings.HasPrefix(c.Field, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// diff compares the two configs field by field, e.g. "Info.GroupID" or "WhiteList",
+// with the values of secret fields hidden
+func diff(old *Config, new *Config) []Change {
+	oldFields, newFields := make(map[string]string), make(map[string]string)
+	flatten("", reflect.ValueOf(*old), oldFields)
+	flatten("", reflect.ValueOf(*new), newFields)
+
+	names := make([]string, 0, len(newFields))
+	for name := range newFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var changes []Change
+	for _, name := range names {
+		if oldFields[name] == newFields[name] {
+			continue
+		}
+		change := Change{Field: name, Old: oldFields[name], New: newFields[name]}
+		if isSecret(name) {
+			change.Old, change.New = redactedValue, redactedValue
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// flatten records the value of every leaf field of v under its dotted path; slices and maps are leaves
+func flatten(prefix string, v reflect.Value, fields map[string]string) {
+	if v.Kind() == reflect.Struct && v.Type().String() != "time.Time" {
+		for i := 0; i < v.NumField(); i++ {
+			if !v.Type().Field(i).IsExported() {
+				continue
+			}
+			flatten(prefix+v.Type().Field(i).Name+".", v.Field(i), fields)
+		}
+		return
+	}
+	name := strings.TrimSuffix(prefix, ".")
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map:
+		data, _ := json.Marshal(v.Interface())
+		fields[name] = string(data)
+	default:
+		fields[name] = fmt.Sprint(v.Interface())
+	}
+}
+
+func isSecret(field string) bool {
+	for _, s := range secrets {
+		if strings.EqualFold(field, s.key) {
+			return true
+		}
+	}
+	return false
+}
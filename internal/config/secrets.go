@@ -0,0 +1,72 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// redactedValue 日志和管理接口中代替密钥显示的值
+const redactedValue = "***"
+
+// secret 一个密钥配置项，可以直接写在配置文件中，也可以从file指定的文件中读取（如Docker secrets）
+type secret struct {
+	key     string
+	fileKey string
+	fileEnv string
+	field   func(c *Config) *string
+}
+
+var secrets = []secret{
+	{"feishu.appSecret", "feishu.appSecretFile", "FEISHU_APP_SECRET_FILE", func(c *Config) *string { return &c.Feishu.AppSecret }},
+	{"feishu.verificationToken", "feishu.verificationTokenFile", "FEISHU_VERIFICATION_TOKEN_FILE", func(c *Config) *string { return &c.Feishu.VerificationToken }},
+	{"feishu.encryptKey", "feishu.encryptKeyFile", "FEISHU_ENCRYPT_KEY_FILE", func(c *Config) *string { return &c.Feishu.EncryptKey }},
+	{"admin.token", "admin.tokenFile", "BOT_ADMIN_TOKEN_FILE", func(c *Config) *string { return &c.Admin.Token }},
+}
+
+// bindSecretFileEnvs lets the file of every secret be given by an environment variable
+func bindSecretFileEnvs() {
+	for _, s := range secrets {
+		viper.BindEnv(s.fileKey, s.fileEnv)
+	}
+}
+
+// readSecretFiles fills in the secrets whose file is configured, trimming the trailing newline.
+// Setting both the value and the file of a secret is an error
+func readSecretFiles(c *Config) error {
+	var problems ValidationError
+	for _, s := range secrets {
+		path := viper.GetString(s.fileKey)
+		if path == "" {
+			continue
+		}
+		field := s.field(c)
+		if *field != "" {
+			problems = append(problems, fmt.Sprintf("only one of %s and %s can be set", s.key, s.fileKey))
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", s.fileKey, err))
+			continue
+		}
+		*field = strings.TrimSpace(string(data))
+	}
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+// Redacted returns a copy of the config with every secret replaced by "***", for logging and the admin api.
+// Secrets which are not set stay empty
+func (c Config) Redacted() Config {
+	for _, s := range secrets {
+		if field := s.field(&c); *field != "" {
+			*field = redactedValue
+		}
+	}
+	return c
+}
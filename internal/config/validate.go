@@ -0,0 +1,132 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"xlab-feishu-robot/internal/lock"
+
+	"github.com/robfig/cron/v3"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// 用户的open_id以ou_开头，群的chat_id以oc_开头
+	openIdPattern = regexp.MustCompile(`^ou_[0-9a-zA-Z]+$`)
+	chatIdPattern = regexp.MustCompile(`^oc_[0-9a-zA-Z]+$`)
+)
+
+// ValidationError lists every problem found in the config
+type ValidationError []string
+
+func (e ValidationError) Error() string {
+	return strings.Join(e, "; ")
+}
+
+// add appends the problems listed by err, or err itself if it is not a ValidationError
+func (e ValidationError) add(err error) ValidationError {
+	var invalid ValidationError
+	if errors.As(err, &invalid) {
+		return append(e, invalid...)
+	}
+	return append(e, err.Error())
+}
+
+// Validate checks that the settings the robot cannot run without are present and well-formed.
+// The returned error is a ValidationError listing all problems
+func (c Config) Validate() error {
+	var problems ValidationError
+	addf := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	required := func(name string, value string) bool {
+		if value == "" {
+			addf("%s is not set", name)
+			return false
+		}
+		return true
+	}
+
+	switch c.Mode {
+	case "", ModeNormal, ModeDryRun:
+	default:
+		addf("mode %q must be %s or %s", c.Mode, ModeNormal, ModeDryRun)
+	}
+
+	required("feishu.appId", c.Feishu.AppId)
+	required("feishu.appSecret", c.Feishu.AppSecret)
+	switch c.Dispatcher.Transport {
+	case "", TransportHTTP:
+		// 事件回调需要校验token，长连接不需要
+		required("feishu.verificationToken", c.Feishu.VerificationToken)
+	case TransportWebSocket:
+	default:
+		addf("dispatcher.transport %q must be %s or %s", c.Dispatcher.Transport, TransportHTTP, TransportWebSocket)
+	}
+
+	switch c.Log.Format {
+	case "", LogFormatText, LogFormatJSON:
+	default:
+		addf("log.format %q must be %s or %s", c.Log.Format, LogFormatText, LogFormatJSON)
+	}
+	if c.Log.Level != "" {
+		if _, err := logrus.ParseLevel(c.Log.Level); err != nil {
+			addf("log.level %q must be one of trace, debug, info, warn, error", c.Log.Level)
+		}
+	}
+
+	switch c.Lock.Type {
+	case "", lock.TypeNone:
+	case lock.TypeFile:
+		required("lock.file", c.Lock.File)
+	default:
+		addf("lock.type %q must be %s or %s", c.Lock.Type, lock.TypeNone, lock.TypeFile)
+	}
+
+	if c.Server.Port <= 0 || c.Server.Port > 65535 {
+		addf("server.port %d is not a valid port", c.Server.Port)
+	}
+
+	if required("Info.GroupID", c.Info.GroupID) && !chatIdPattern.MatchString(c.Info.GroupID) {
+		addf("Info.GroupID %q is not a chat_id (oc_...)", c.Info.GroupID)
+	}
+	required("Info.NodeToken", c.Info.NodeToken)
+	if required("Info.PersonInChargeID", c.Info.PersonInChargeID) && !openIdPattern.MatchString(c.Info.PersonInChargeID) {
+		addf("Info.PersonInChargeID %q is not an open_id (ou_...)", c.Info.PersonInChargeID)
+	}
+	for i, openId := range c.WhiteList {
+		if !openIdPattern.MatchString(openId) {
+			addf("whiteList[%d] %q is not an open_id (ou_...)", i, openId)
+		}
+	}
+
+	if c.Schedule.Timezone != "" {
+		if _, err := time.LoadLocation(c.Schedule.Timezone); err != nil {
+			addf("schedule.timezone %q: %v", c.Schedule.Timezone, err)
+		}
+	}
+	for name, spec := range c.Schedule.Jobs {
+		if _, err := cron.ParseStandard(spec); err != nil {
+			addf("schedule.jobs.%s %q: %v", name, spec, err)
+		}
+	}
+
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+// Location returns the timezone of scheduled jobs, the local timezone if not configured or invalid
+func (c Config) Location() *time.Location {
+	if c.Schedule.Timezone == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(c.Schedule.Timezone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
@@ -0,0 +1,174 @@
+package controller
+
+import (
+	"context"
+	"time"
+	"xlab-feishu-robot/internal/config"
+	"xlab-feishu-robot/internal/store"
+
+	"github.com/YasyaKarasu/feishuapi"
+	"github.com/sirupsen/logrus"
+)
+
+// 成员对提醒消息回复表情（默认DONE）表示“正在写”，之后ack.days天内的提醒不再@该成员
+
+const (
+	remindersStoreName = "reminder_messages"
+	acksStoreName      = "acknowledgements"
+	defaultAckDays     = 3
+)
+
+// ReminderMessage 已发送的提醒消息，以及其中@的成员
+type ReminderMessage struct {
+	Sent    time.Time `json:"sent"`
+	Members []string  `json:"members"`
+}
+
+// Acknowledgement 成员对提醒的确认
+type Acknowledgement struct {
+	MessageId string    `json:"message_id"`
+	Acked     time.Time `json:"acked"`
+	Until     time.Time `json:"until"`
+}
+
+// 提醒消息和确认每次使用时从store读取，多个副本看到的是同一份数据
+
+// reminderMessages 提醒消息的message_id到消息的映射
+type reminderMessages map[string]ReminderMessage
+
+// acknowledgements 成员的open_id到确认的映射
+type acknowledgements map[string]Acknowledgement
+
+// recordReminder remembers which members a reminder message mentioned, so that reactions can be mapped back.
+// Reminders older than two months can no longer be acknowledged and are dropped
+func recordReminder(ctx context.Context, messageId string, members []feishuapi.GroupMember) {
+	now := time.Now()
+	ids := make([]string, 0, len(members))
+	for _, member := range members {
+		ids = append(ids, member.MemberId)
+	}
+	reminders := make(reminderMessages)
+	err := store.Update(remindersStoreName, &reminders, func() bool {
+		reminders[messageId] = ReminderMessage{Sent: now, Members: ids}
+		for id, reminder := range reminders {
+			if now.Sub(reminder.Sent) > 62*24*time.Hour {
+				delete(reminders, id)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		logrus.WithContext(ctx).Error("Failed to save reminder messages: ", err)
+	}
+
+	acks := make(acknowledgements)
+	err = store.Update(acksStoreName, &acks, func() bool {
+		expired := false
+		for id, ack := range acks {
+			if now.After(ack.Until) {
+				delete(acks, id)
+				expired = true
+			}
+		}
+		return expired
+	})
+	if err != nil {
+		logrus.WithContext(ctx).Error("Failed to save acknowledgements: ", err)
+	}
+}
+
+// acknowledge handles a reaction to a message; it only counts when the message is a reminder mentioning the member
+// and the emoji is the configured one
+func acknowledge(ctx context.Context, messageId string, openId string, emoji string) {
+	c := config.C()
+	if !isAckEmoji(c, emoji) {
+		return
+	}
+	reminders := make(reminderMessages)
+	if err := store.Load(remindersStoreName, &reminders); err != nil {
+		logrus.WithContext(ctx).Error("Failed to load reminder messages: ", err)
+		return
+	}
+	reminder, ok := reminders[messageId]
+	if !ok || !contains(reminder.Members, openId) {
+		return
+	}
+	days := c.Ack.Days
+	if days <= 0 {
+		days = defaultAckDays
+	}
+	now := time.Now()
+	acks := make(acknowledgements)
+	err := store.Update(acksStoreName, &acks, func() bool {
+		acks[openId] = Acknowledgement{MessageId: messageId, Acked: now, Until: now.AddDate(0, 0, days)}
+		return true
+	})
+	if err != nil {
+		logrus.WithContext(ctx).Error("Failed to save acknowledgements: ", err)
+		return
+	}
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"open id": openId, "message id": messageId, "days": days}).Info("Member acknowledged reminder")
+}
+
+// unacknowledge withdraws the acknowledgement when the reaction to the same reminder is removed
+func unacknowledge(ctx context.Context, messageId string, openId string, emoji string) {
+	if !isAckEmoji(config.C(), emoji) {
+		return
+	}
+	acks := make(acknowledgements)
+	withdrawn := false
+	err := store.Update(acksStoreName, &acks, func() bool {
+		if ack, ok := acks[openId]; ok && ack.MessageId == messageId {
+			delete(acks, openId)
+			withdrawn = true
+		}
+		return withdrawn
+	})
+	if err != nil {
+		logrus.WithContext(ctx).Error("Failed to save acknowledgements: ", err)
+		return
+	}
+	if withdrawn {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"open id": openId, "message id": messageId}).Info("Member withdrew acknowledgement")
+	}
+}
+
+// isAcknowledged reports whether the member should not be mentioned in reminders at t
+func isAcknowledged(ctx context.Context, openId string, t time.Time) bool {
+	acks := make(acknowledgements)
+	if err := store.Load(acksStoreName, &acks); err != nil {
+		logrus.WithContext(ctx).Error("Failed to load acknowledgements: ", err)
+	}
+	ack, ok := acks[openId]
+	return ok && t.Before(ack.Until)
+}
+
+// forgetAcknowledgement removes the acknowledgement of a member who left the group
+func forgetAcknowledgement(ctx context.Context, openId string) (Acknowledgement, bool) {
+	acks := make(acknowledgements)
+	var ack Acknowledgement
+	var ok bool
+	err := store.Update(acksStoreName, &acks, func() bool {
+		if ack, ok = acks[openId]; ok {
+			delete(acks, openId)
+		}
+		return ok
+	})
+	if err != nil {
+		logrus.WithContext(ctx).Error("Failed to save acknowledgements: ", err)
+	}
+	return ack, ok
+}
+
+func isAckEmoji(c *config.Config, emoji string) bool {
+	return c.Ack.Emoji != "" && emoji == c.Ack.Emoji
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+package controller
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+	"xlab-feishu-robot/internal/config"
+	"xlab-feishu-robot/internal/pkg"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// AdminAuth checks the "Authorization: Bearer <token>" header of admin api requests against admin.token in config.
+// If no token is configured, the admin api is disabled
+func AdminAuth(c *gin.Context) {
+	token := config.C().Admin.Token
+	if token == "" {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "admin api is disabled"})
+		return
+	}
+	given := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+		logrus.WithFields(logrus.Fields{"client ip": c.ClientIP(), "path": c.Request.URL.Path}).Warn("Unauthorized admin api request")
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	c.Next()
+}
+
+// JobRunResult 手动执行任务的结果
+type JobRunResult struct {
+	JobRecord
+	Messages []Message `json:"messages"`
+}
+
+// @Summary run a scheduled job manually
+// @Description With dry_run=true the messages are returned instead of being sent
+// @Tags admin
+// @Produce json
+// @Security AdminToken
+// @Param name path string true "job name" Enums(remindFirstDay, sendRemindMessage, sendMonthlyReport)
+// @Param dry_run query bool false "return the messages without sending them"
+// @Success 200 {object} JobRunResult
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /api/admin/jobs/{name}/run [post]
+func RunJob(c *gin.Context) {
+	j, ok := findJob(c.Param("name"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "job not found: " + c.Param("name")})
+		return
+	}
+	dryRun, err := strconv.ParseBool(c.DefaultQuery("dry_run", "false"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid dry_run: " + c.Query("dry_run")})
+		return
+	}
+
+	logrus.WithFields(logrus.Fields{"job": j.Name, "dry run": dryRun}).Info("Run job manually")
+	run := &jobRun{DryRun: dryRun}
+	record := runJob(j, TriggerManual, run)
+	if run.Messages == nil {
+		run.Messages = make([]Message, 0)
+	}
+	c.JSON(http.StatusOK, JobRunResult{JobRecord: record, Messages: run.Messages})
+}
+
+// @Summary list scheduled jobs
+// @Description Next and previous run times come from the cron scheduler, recent runs from the persisted job history
+// @Tags admin
+// @Produce json
+// @Security AdminToken
+// @Success 200 {array} JobStatus
+// @Failure 401 {object} map[string]string
+// @Router /api/admin/jobs [get]
+func ListJobs(c *gin.Context) {
+	c.JSON(http.StatusOK, jobStatuses(c.Request.Context()))
+}
+
+// @Summary list messages captured in dry-run mode
+// @Description Only the latest messages are kept, oldest first
+// @Tags admin
+// @Produce json
+// @Security AdminToken
+// @Success 200 {array} pkg.OutboxMessage
+// @Failure 401 {object} map[string]string
+// @Router /api/admin/outbox [get]
+func GetOutbox(c *gin.Context) {
+	c.JSON(http.StatusOK, pkg.Outbox())
+}
+
+// @Summary current config
+// @Description The config in effect, including changes reloaded from the config file, with secrets shown as "***"
+// @Tags admin
+// @Produce json
+// @Security AdminToken
+// @Success 200 {object} map[string]any
+// @Failure 401 {object} map[string]string
+// @Router /api/admin/config [get]
+func GetConfig(c *gin.Context) {
+	c.JSON(http.StatusOK, config.C().Redacted())
+}
@@ -0,0 +1,111 @@
+package controller
+
+import (
+	"context"
+	"time"
+	"xlab-feishu-robot/internal/config"
+	"xlab-feishu-robot/internal/metrics"
+	"xlab-feishu-robot/internal/store"
+
+	"github.com/robfig/cron/v3"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultCatchUpWindow 未配置schedule.catchUpWindow时的补执行时间窗口
+const defaultCatchUpWindow = 24 * time.Hour
+
+const lastSuccessStoreName = "job_last_success"
+
+// lastSuccess 每个任务最近一次成功执行的开始时间
+// 每次使用时从store读取，接手锁的副本能看到上一个副本刚执行过的任务
+type lastSuccess map[string]time.Time
+
+func getLastSuccess(ctx context.Context, name string) (time.Time, bool) {
+	times := make(lastSuccess)
+	if err := store.Load(lastSuccessStoreName, &times); err != nil {
+		logrus.WithContext(ctx).Error("Failed to load last successful job runs: ", err)
+	}
+	t, ok := times[name]
+	return t, ok
+}
+
+// setLastSuccess records t as the last successful run of the job, unless a later run is already recorded
+func setLastSuccess(ctx context.Context, name string, t time.Time) {
+	times := make(lastSuccess)
+	err := store.Update(lastSuccessStoreName, &times, func() bool {
+		if !t.After(times[name]) {
+			return false
+		}
+		times[name] = t
+		return true
+	})
+	if err != nil {
+		logrus.WithContext(ctx).Error("Failed to save last successful job runs: ", err)
+	}
+}
+
+// catchUpMissedJobs runs every job whose scheduled time passed while the bot was down, once.
+// A job missed longer ago than the grace window is not run, but recorded as missed in the job history,
+// so that it shows up in /api/admin/jobs and "@bot jobs" instead of being skipped silently
+func catchUpMissedJobs(ctx context.Context) {
+	c := config.C()
+	if remindersPaused(ctx) {
+		logrus.WithContext(ctx).Warn("Reminders are paused, skip catch-up")
+		return
+	}
+	window := c.Schedule.CatchUpWindow
+	if window <= 0 {
+		window = defaultCatchUpWindow
+	}
+	now := time.Now().In(c.Location())
+
+	for _, j := range jobs {
+		last, ok := getLastSuccess(ctx, j.Name)
+		if !ok {
+			// 第一次部署，没有可以比较的执行记录
+			logrus.WithContext(ctx).WithField("job", j.Name).Info("No successful run recorded, skip catch-up")
+			continue
+		}
+		schedule, err := cron.ParseStandard(j.spec(c))
+		if err != nil {
+			logrus.WithContext(ctx).WithField("job", j.Name).Error("Failed to parse cron spec: ", err)
+			continue
+		}
+		missed := lastMissedRun(schedule, last.In(now.Location()), now)
+		if missed.IsZero() {
+			continue
+		}
+
+		if now.Sub(missed) > window {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{
+				"job":       j.Name,
+				"scheduled": missed,
+				"window":    window,
+			}).Error("Job was missed during downtime and is outside the catch-up window, not running it")
+			addJobRecord(ctx, JobRecord{
+				Job:     j.Name,
+				Trigger: TriggerCatchUp,
+				Start:   now,
+				End:     now,
+				Outcome: JobMissed,
+				Error:   "scheduled at " + missed.Format(time.RFC3339) + ", outside the catch-up window",
+			})
+			metrics.JobRuns.WithLabelValues(j.Name, TriggerCatchUp, JobMissed).Inc()
+			// 记录为已处理，下次启动时不再重复记录这次错过的执行
+			setLastSuccess(ctx, j.Name, missed)
+			continue
+		}
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"job": j.Name, "scheduled": missed}).Warn("Job was missed during downtime, running it now")
+		runJob(j, TriggerCatchUp, &jobRun{Scheduled: missed})
+	}
+}
+
+// lastMissedRun returns the latest scheduled time after last and not after now,
+// or the zero time if the job was not scheduled in between
+func lastMissedRun(schedule cron.Schedule, last time.Time, now time.Time) time.Time {
+	var missed time.Time
+	for t := schedule.Next(last); !t.After(now); t = schedule.Next(t) {
+		missed = t
+	}
+	return missed
+}
@@ -0,0 +1,68 @@
+package controller
+
+import (
+	"context"
+	"xlab-feishu-robot/internal/config"
+	"xlab-feishu-robot/internal/dispatcher"
+	"xlab-feishu-robot/internal/model"
+
+	"github.com/sirupsen/logrus"
+)
+
+func memberAdded(ctx context.Context, event dispatcher.Event, e *model.ChatMemberUserEvent) error {
+	for _, user := range e.Users {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"chat id": e.Chat_id, "name": user.Name, "open id": user.User_id.Open_id}).Info("User joined group")
+		if e.Chat_id == config.C().Info.GroupID {
+			onboard(ctx, user.User_id.Open_id, user.Name)
+		}
+	}
+	return nil
+}
+
+func memberDeleted(ctx context.Context, event dispatcher.Event, e *model.ChatMemberUserEvent) error {
+	for _, user := range e.Users {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"chat id": e.Chat_id, "name": user.Name, "open id": user.User_id.Open_id}).Info("User left group")
+		if e.Chat_id == config.C().Info.GroupID {
+			memberLeft(ctx, user.User_id.Open_id, user.Name)
+		}
+	}
+	return nil
+}
+
+func botAdded(ctx context.Context, event dispatcher.Event, e *model.ChatMemberBotEvent) error {
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"chat id": e.Chat_id, "name": e.Name}).Info("Robot was added to group")
+	if e.Chat_id == config.C().Info.GroupID && remindersPaused(ctx) {
+		setReminderPaused(ctx, false, e.Chat_id)
+	}
+	return nil
+}
+
+func botDeleted(ctx context.Context, event dispatcher.Event, e *model.ChatMemberBotEvent) error {
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"chat id": e.Chat_id, "name": e.Name}).Info("Robot was removed from group")
+	if e.Chat_id == config.C().Info.GroupID {
+		setReminderPaused(ctx, true, e.Chat_id)
+	}
+	return nil
+}
+
+func messageRead(ctx context.Context, event dispatcher.Event, e *model.MessageReadEvent) error {
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"reader": e.Reader.Reader_id.Open_id, "messages": e.Message_id_list}).Debug("Messages read")
+	return nil
+}
+
+func reactionCreated(ctx context.Context, event dispatcher.Event, e *model.MessageReactionEvent) error {
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"message id": e.Message_id, "emoji": e.Reaction_type.Emoji_type, "open id": e.User_id.Open_id}).Debug("Reaction added")
+	acknowledge(ctx, e.Message_id, e.User_id.Open_id, e.Reaction_type.Emoji_type)
+	return nil
+}
+
+func reactionDeleted(ctx context.Context, event dispatcher.Event, e *model.MessageReactionEvent) error {
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"message id": e.Message_id, "emoji": e.Reaction_type.Emoji_type, "open id": e.User_id.Open_id}).Debug("Reaction removed")
+	unacknowledge(ctx, e.Message_id, e.User_id.Open_id, e.Reaction_type.Emoji_type)
+	return nil
+}
+
+func driveFileEdited(ctx context.Context, event dispatcher.Event, e *model.DriveFileEditEvent) error {
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"file type": e.File_type, "file token": e.File_token}).Info("Document edited")
+	return nil
+}
@@ -0,0 +1,95 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"xlab-feishu-robot/internal/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+// @Summary export a month's knowledge tree records
+// @Description JSON output follows model.MonthlyExport, whose schema_version is bumped on incompatible changes.
+// @Description Only records with a node link are exported, the empty records inserted by prefill are left out, as in the monthly summary.
+// @Description Records contain open_ids, so the admin token is required
+// @Tags export
+// @Produce json
+// @Produce text/markdown
+// @Produce text/csv
+// @Param year path int true "year, e.g. 2023"
+// @Param month path int true "month, 1-12"
+// @Param format query string false "output format" Enums(json, md, csv) default(json)
+// @Success 200 {object} model.MonthlyExport
+// @Failure 400 {string} string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {string} string
+// @Security AdminToken
+// @Router /api/export/{year}/{month} [get]
+func Export(c *gin.Context) {
+	year, err := strconv.Atoi(c.Param("year"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid year")
+		return
+	}
+	month, err := strconv.Atoi(c.Param("month"))
+	if err != nil || month < 1 || month > 12 {
+		c.String(http.StatusBadRequest, "invalid month")
+		return
+	}
+
+	table := getTableByTime(c.Request.Context(), year, month)
+	if table.TableId == "" {
+		c.String(http.StatusNotFound, "no table found for %d-%02d", year, month)
+		return
+	}
+	// 与月度汇总一致，不导出prefill插入的空记录
+	records := writtenRecords(getAllRecordsInTable(table))
+
+	switch format := c.DefaultQuery("format", "json"); format {
+	case "json":
+		c.JSON(http.StatusOK, model.NewMonthlyExport(year, month, records))
+	case "md":
+		c.Data(http.StatusOK, "text/markdown; charset=utf-8", []byte(renderSummaryMarkdown(year, month, records)))
+	case "csv":
+		data, err := renderRecordsCSV(records)
+		if err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%d-%02d.csv", year, month))
+		c.Data(http.StatusOK, "text/csv; charset=utf-8", data)
+	default:
+		c.String(http.StatusBadRequest, "unsupported format: %s", format)
+	}
+}
+
+// renderRecordsCSV renders records as CSV, with the same columns as the knowledge tree table
+func renderRecordsCSV(records []model.Record) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"维护人", "一句话介绍", "维护节点链接", "👍", "创建时间"})
+	for _, record := range records {
+		maintainers := make([]string, 0, len(record.Maintainers))
+		for _, maintainer := range record.Maintainers {
+			maintainers = append(maintainers, maintainer.Name)
+		}
+		links := make([]string, 0, len(record.NodeLink))
+		for _, link := range record.NodeLink {
+			links = append(links, link.URL)
+		}
+		w.Write([]string{
+			strings.Join(maintainers, "、"),
+			record.OneLineIntroduction,
+			strings.Join(links, "\n"),
+			strconv.Itoa(record.LikeCount),
+			time.UnixMilli(int64(record.TimeStamp)).Format("2006-01-02 15:04:05"),
+		})
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
@@ -0,0 +1,199 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+	"xlab-feishu-robot/internal/config"
+	"xlab-feishu-robot/internal/pkg"
+
+	"github.com/gin-gonic/gin"
+)
+
+// tenant_access_token 的有效期为2小时，StartTokenTimer每105分钟刷新一次
+const tokenTTL = 2 * time.Hour
+
+// readinessCacheTTL 就绪检查会请求飞书接口，结果缓存一段时间，避免频繁的健康检查触发限流
+const readinessCacheTTL = 30 * time.Second
+
+// readinessTimeout 就绪检查最多等待的时间，飞书接口无响应时对应的检查记为超时
+const readinessTimeout = 5 * time.Second
+
+// CheckResult 单项检查的结果
+type CheckResult struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// Readiness 就绪检查的结果
+type Readiness struct {
+	Ready     bool                   `json:"ready"`
+	CheckedAt time.Time              `json:"checked_at"`
+	Checks    map[string]CheckResult `json:"checks"`
+}
+
+var readiness struct {
+	sync.Mutex
+	last Readiness
+	// running 正在进行的检查，检查结束时关闭，期间的其他请求等待其结果
+	running chan struct{}
+}
+
+// @Summary liveness
+// @Description Always 200 while the process is serving requests
+// @Tags health
+// @Produce json
+// @Success 200 {object} map[string]string
+// @Router /healthz [get]
+func Healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// @Summary readiness
+// @Description Checks that the last change of the config file was accepted, tenant token freshness, the knowledge tree node, the group and the cron scheduler.
+// @Description Responds 503 if any check fails or does not finish within 5 seconds; results are cached for 30 seconds
+// @Tags health
+// @Produce json
+// @Success 200 {object} Readiness
+// @Failure 503 {object} Readiness
+// @Router /readyz [get]
+func Readyz(c *gin.Context) {
+	result := checkReadiness()
+	status := http.StatusOK
+	if !result.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, result)
+}
+
+// checkReadiness runs all readiness checks, or returns the cached result if it is recent enough.
+// The checks run without holding the lock, so a slow feishu api cannot block other probes
+// for longer than readinessTimeout; concurrent probes share one run of the checks
+func checkReadiness() Readiness {
+	readiness.Lock()
+	if time.Since(readiness.last.CheckedAt) < readinessCacheTTL {
+		defer readiness.Unlock()
+		return readiness.last
+	}
+	if running := readiness.running; running != nil {
+		readiness.Unlock()
+		<-running
+		readiness.Lock()
+		defer readiness.Unlock()
+		return readiness.last
+	}
+	running := make(chan struct{})
+	readiness.running = running
+	readiness.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), readinessTimeout)
+	defer cancel()
+	result := runChecks(ctx, map[string]func() error{
+		"config":       checkConfig,
+		"tenant_token": checkTenantToken,
+		"node_token":   checkNodeToken,
+		"group":        checkGroup,
+		"scheduler":    checkScheduler,
+	})
+
+	readiness.Lock()
+	defer readiness.Unlock()
+	readiness.last = result
+	readiness.running = nil
+	close(running)
+	return result
+}
+
+// runChecks runs the checks concurrently. Checks not finished when ctx is done are reported as timed out,
+// and are left running in the background since the feishu api client cannot be cancelled
+func runChecks(ctx context.Context, checks map[string]func() error) Readiness {
+	type outcome struct {
+		name string
+		err  error
+	}
+	outcomes := make(chan outcome, len(checks))
+	for name, check := range checks {
+		go func(name string, check func() error) {
+			outcomes <- outcome{name: name, err: runCheck(check)}
+		}(name, check)
+	}
+
+	result := Readiness{Ready: true, Checks: make(map[string]CheckResult)}
+	for len(result.Checks) < len(checks) {
+		select {
+		case o := <-outcomes:
+			if o.err != nil {
+				result.Ready = false
+				result.Checks[o.name] = CheckResult{Error: o.err.Error()}
+			} else {
+				result.Checks[o.name] = CheckResult{OK: true}
+			}
+		case <-ctx.Done():
+			for name := range checks {
+				if _, ok := result.Checks[name]; !ok {
+					result.Ready = false
+					result.Checks[name] = CheckResult{Error: "check did not finish within " + readinessTimeout.String()}
+				}
+			}
+		}
+	}
+	result.CheckedAt = time.Now()
+	return result
+}
+
+// runCheck runs the check, turning a panic in the feishu api client into an error
+func runCheck(check func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return check()
+}
+
+// checkConfig fails while the config file on disk has a change which was rejected,
+// so that a broken file is noticed before the next restart fails on it
+func checkConfig() error {
+	if err := config.ReloadError(); err != nil {
+		return fmt.Errorf("last change of the config file was rejected: %w", err)
+	}
+	return nil
+}
+
+func checkTenantToken() error {
+	refreshed := pkg.TokenRefreshedAt()
+	if refreshed.IsZero() {
+		return errors.New("tenant access token has never been fetched")
+	}
+	if age := time.Since(refreshed); age > tokenTTL {
+		return fmt.Errorf("tenant access token expired, last refreshed %s ago", age.Round(time.Second))
+	}
+	return nil
+}
+
+func checkNodeToken() error {
+	c := config.C()
+	node := pkg.Cli.KnowledgeSpaceGetNodeInfo(c.Info.NodeToken)
+	if node == nil || node.ObjToken == "" {
+		return fmt.Errorf("knowledge tree node %q cannot be resolved", c.Info.NodeToken)
+	}
+	return nil
+}
+
+func checkGroup() error {
+	c := config.C()
+	if pkg.Cli.GroupGetInfo(c.Info.GroupID) == nil {
+		return fmt.Errorf("group %q is not accessible", c.Info.GroupID)
+	}
+	return nil
+}
+
+func checkScheduler() error {
+	if !cronRunning.Load() {
+		return errors.New("cron scheduler is not running")
+	}
+	return nil
+}
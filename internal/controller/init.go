@@ -3,12 +3,23 @@ package controller
 import (
 	"xlab-feishu-robot/internal/chat"
 	"xlab-feishu-robot/internal/dispatcher"
+	"xlab-feishu-robot/internal/model"
 )
 
 func InitEvent() {
-	dispatcher.RegisterListener(chat.Receive, "im.message.receive_v1")
+	dispatcher.RegisterTyped(chat.Receive, model.EventMessageReceive)
+	dispatcher.RegisterTyped(memberAdded, model.EventChatMemberAdded)
+	dispatcher.RegisterTyped(memberDeleted, model.EventChatMemberDeleted)
+	dispatcher.RegisterTyped(botAdded, model.EventBotAdded)
+	dispatcher.RegisterTyped(botDeleted, model.EventBotDeleted)
+	dispatcher.RegisterTyped(messageRead, model.EventMessageRead)
+	dispatcher.RegisterTyped(reactionCreated, model.EventReactionCreated)
+	dispatcher.RegisterTyped(reactionDeleted, model.EventReactionDeleted)
+	dispatcher.RegisterTyped(driveFileEdited, model.EventDriveFileEdit)
 	InitMessageBind()
 }
 
 func InitMessageBind() {
+	chat.GroupMessageRegister(prefillCommand, "prefill")
+	chat.GroupMessageRegister(jobsCommand, "jobs")
 }
@@ -0,0 +1,287 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"xlab-feishu-robot/internal/config"
+	"xlab-feishu-robot/internal/log"
+	"xlab-feishu-robot/internal/metrics"
+	"xlab-feishu-robot/internal/model"
+	"xlab-feishu-robot/internal/pkg"
+	"xlab-feishu-robot/internal/store"
+
+	"github.com/YasyaKarasu/feishuapi"
+	"github.com/robfig/cron/v3"
+	"github.com/sirupsen/logrus"
+)
+
+// job 一个定时任务
+type job struct {
+	Name string
+	// cron表达式
+	Spec string
+	// 任务的说明，添加任务时打印
+	Description string
+	Run         func(run *jobRun)
+}
+
+var jobs = []job{
+	{
+		Name:        "remindFirstDay",
+		Spec:        "0 10 1 * *",
+		Description: "remind the person in charge and group members on the 1st of every month at 10:00",
+		Run:         remindFirstDay,
+	},
+	{
+		Name:        "sendRemindMessage",
+		Spec:        "0 10 15,23 * *",
+		Description: "check who has not written the knowledge tree document on the 15th/23rd of every month at 10:00",
+		Run:         sendRemindMessage,
+	},
+	{
+		Name:        "sendMonthlyReport",
+		Spec:        "@monthly",
+		Description: "send last month's monthly report on the 1st of every month at 0:00",
+		Run:         sendMonthlyReport,
+	},
+}
+
+func findJob(name string) (job, bool) {
+	for _, j := range jobs {
+		if j.Name == name {
+			return j, true
+		}
+	}
+	return job{}, false
+}
+
+// cronTimer 执行定时任务的调度器，由Remind创建，调度配置变化时整体替换
+var cronTimer *cron.Cron
+
+// retiredTimers 重新调度时被替换的调度器停止后返回的context，其上的任务全部结束后done
+var retiredTimers []context.Context
+
+// cronMu 保护cronTimer、retiredTimers和jobEntries
+var cronMu sync.Mutex
+
+// spec returns the cron spec of the job, which may be overridden by schedule.jobs in c
+func (j job) spec(c *config.Config) string {
+	// viper会把map的key转为小写
+	for name, spec := range c.Schedule.Jobs {
+		if strings.EqualFold(name, j.Name) {
+			return spec
+		}
+	}
+	return j.Spec
+}
+
+// cronRunning 定时器是否在运行，用于就绪检查
+var cronRunning atomic.Bool
+
+// jobEntries 任务名到cron entry的映射，用于查询下次执行时间
+var jobEntries = make(map[string]cron.EntryID)
+
+// jobRun 一次任务执行，记录执行过程中发送的消息
+// DryRun为true时只记录消息，不真正发送
+type jobRun struct {
+	DryRun bool
+	// 本次执行对应的计划时间，补执行时是错过的时间，手动执行时为执行开始的时间
+	Scheduled time.Time
+	Messages  []Message
+	// 发送失败的消息数
+	Failed int
+	// 带有执行id的context，由runJob设置
+	ctx context.Context
+}
+
+// logger returns an entry which logs with the run's id
+func (r *jobRun) logger() *logrus.Entry {
+	return logrus.WithContext(r.ctx)
+}
+
+// Message 任务发送（或在dry run时将要发送）的消息
+type Message struct {
+	ReceiveIdType feishuapi.MsgReceiverType `json:"receive_id_type" swaggertype:"string" example:"chat_id"`
+	ReceiveId     string                    `json:"receive_id"`
+	Content       string                    `json:"content"`
+}
+
+// send sends the message unless in dry run, returns the message id, which is empty if the message was not sent
+func (r *jobRun) send(receiveIdType feishuapi.MsgReceiverType, receiveId string, msg string) string {
+	r.Messages = append(r.Messages, Message{ReceiveIdType: receiveIdType, ReceiveId: receiveId, Content: msg})
+	if r.DryRun {
+		r.logger().WithFields(logrus.Fields{"receive id": receiveId, "message": msg}).Info("Dry run, message not sent")
+		return ""
+	}
+	messageId, ok := pkg.MessageSend(r.ctx, receiveIdType, receiveId, feishuapi.Text, msg)
+	if !ok {
+		r.Failed++
+	}
+	return messageId
+}
+
+// 任务执行结果
+const (
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	// 停机期间错过、且超出补执行时间窗口的任务
+	JobMissed = "missed"
+)
+
+// 任务触发方式
+const (
+	TriggerCron   = "cron"
+	TriggerManual = "manual"
+	// 启动时补执行停机期间错过的任务
+	TriggerCatchUp = "catch-up"
+)
+
+// JobRecord 一次任务执行的记录
+type JobRecord struct {
+	// 执行id，同时作为本次执行期间日志的关联id
+	Id       string    `json:"id"`
+	Job      string    `json:"job"`
+	Trigger  string    `json:"trigger"`
+	DryRun   bool      `json:"dry_run"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Outcome  string    `json:"outcome"`
+	Error    string    `json:"error,omitempty"`
+	Messages int       `json:"messages"`
+}
+
+// jobHistorySize 持久化保存的最近执行记录条数
+const jobHistorySize = 100
+
+const jobHistoryStoreName = "job_history"
+
+// jobHistory 最近的执行记录，每次使用时从store读取，多个副本的记录写入同一份历史
+type jobHistory []JobRecord
+
+func addJobRecord(ctx context.Context, record JobRecord) {
+	var records jobHistory
+	err := store.Update(jobHistoryStoreName, &records, func() bool {
+		records = append(records, record)
+		if len(records) > jobHistorySize {
+			records = records[len(records)-jobHistorySize:]
+		}
+		return true
+	})
+	if err != nil {
+		logrus.WithContext(ctx).Error("Failed to save job history: ", err)
+	}
+}
+
+// recentJobRecords returns at most n latest records of the job, newest first
+func recentJobRecords(ctx context.Context, name string, n int) []JobRecord {
+	var records jobHistory
+	if err := store.Load(jobHistoryStoreName, &records); err != nil {
+		logrus.WithContext(ctx).Error("Failed to load job history: ", err)
+	}
+	result := make([]JobRecord, 0, n)
+	for i := len(records) - 1; i >= 0 && len(result) < n; i-- {
+		if records[i].Job == name {
+			result = append(result, records[i])
+		}
+	}
+	return result
+}
+
+// runJob runs the job and records its outcome in the job history.
+// A panicking job is recovered and recorded as failed
+func runJob(j job, trigger string, run *jobRun) (record JobRecord) {
+	record = JobRecord{Id: log.NewCorrelationId(), Job: j.Name, Trigger: trigger, DryRun: run.DryRun, Start: time.Now()}
+	if run.Scheduled.IsZero() {
+		run.Scheduled = record.Start.In(config.C().Location())
+	}
+	run.ctx = log.WithCorrelation(context.Background(), record.Id)
+	run.logger().WithFields(logrus.Fields{"job": j.Name, "trigger": trigger, "dry run": run.DryRun, "scheduled": run.Scheduled}).Info("Job started")
+
+	defer func() {
+		if err := recover(); err != nil {
+			record.Error = fmt.Sprint("panic: ", err)
+		} else if run.Failed > 0 {
+			record.Error = fmt.Sprintf("%d of %d messages failed to send", run.Failed, len(run.Messages))
+		}
+		record.Outcome = JobSucceeded
+		if record.Error != "" {
+			record.Outcome = JobFailed
+		}
+		record.End = time.Now()
+		record.Messages = len(run.Messages)
+		addJobRecord(run.ctx, record)
+		metrics.JobRuns.WithLabelValues(j.Name, trigger, record.Outcome).Inc()
+		metrics.JobDuration.WithLabelValues(j.Name).Observe(record.End.Sub(record.Start).Seconds())
+		if record.Outcome == JobSucceeded && !run.DryRun {
+			setLastSuccess(run.ctx, j.Name, record.Start)
+		}
+		run.logger().WithFields(logrus.Fields{
+			"job":      j.Name,
+			"outcome":  record.Outcome,
+			"error":    record.Error,
+			"duration": record.End.Sub(record.Start),
+		}).Info("Job finished")
+	}()
+
+	j.Run(run)
+	return
+}
+
+// JobStatus 任务的调度状态与最近的执行记录
+type JobStatus struct {
+	Name        string      `json:"name"`
+	Spec        string      `json:"spec"`
+	Description string      `json:"description"`
+	Next        time.Time   `json:"next"`
+	Prev        time.Time   `json:"prev"`
+	RecentRuns  []JobRecord `json:"recent_runs"`
+}
+
+// jobStatuses returns the status of every job, with next run times taken from the cron entries
+func jobStatuses(ctx context.Context) []JobStatus {
+	cronMu.Lock()
+	defer cronMu.Unlock()
+	result := make([]JobStatus, 0, len(jobs))
+	for _, j := range jobs {
+		status := JobStatus{
+			Name:        j.Name,
+			Spec:        j.spec(config.C()),
+			Description: j.Description,
+			RecentRuns:  recentJobRecords(ctx, j.Name, 10),
+		}
+		if id, ok := jobEntries[j.Name]; ok && cronTimer != nil {
+			entry := cronTimer.Entry(id)
+			status.Next = entry.Next
+			status.Prev = entry.Prev
+		}
+		result = append(result, status)
+	}
+	return result
+}
+
+// jobsCommand handles "@bot jobs" in the group, replying with the same view as /api/admin/jobs
+func jobsCommand(ctx context.Context, messageevent *model.MessageEvent) {
+	const timeLayout = "2006-01-02 15:04"
+	var sb strings.Builder
+	sb.WriteString("定时任务状态：")
+	for _, status := range jobStatuses(ctx) {
+		sb.WriteString(fmt.Sprintf("\n%s（%s）", status.Name, status.Spec))
+		if !status.Next.IsZero() {
+			sb.WriteString("\n  下次执行：" + status.Next.Format(timeLayout))
+		}
+		if len(status.RecentRuns) == 0 {
+			sb.WriteString("\n  尚无执行记录")
+			continue
+		}
+		last := status.RecentRuns[0]
+		sb.WriteString(fmt.Sprintf("\n  上次执行：%s（%s，%s，发送%d条消息）", last.Start.Format(timeLayout), last.Trigger, last.Outcome, last.Messages))
+		if last.Error != "" {
+			sb.WriteString("\n  错误：" + last.Error)
+		}
+	}
+	pkg.MessageEnqueue(ctx, feishuapi.GroupChatId, messageevent.Message.Chat_id, feishuapi.Text, sb.String())
+}
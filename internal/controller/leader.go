@@ -0,0 +1,121 @@
+package controller
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+	"xlab-feishu-robot/internal/config"
+	"xlab-feishu-robot/internal/lock"
+
+	"github.com/sirupsen/logrus"
+)
+
+// defaultLockTTL 未配置lock.ttl时锁的租约时长
+const defaultLockTTL = 30 * time.Second
+
+// unlockTimeout 停机时释放锁的超时时间，停机等待已经超时时也会尝试释放锁
+const unlockTimeout = 5 * time.Second
+
+// isLeader 当前进程是否持有锁，只有持有锁的进程执行定时任务
+var isLeader atomic.Bool
+
+var locker lock.Locker
+
+// stopRenew 关闭后停止续约，续约的goroutine退出后关闭renewDone
+var (
+	stopRenew = make(chan struct{})
+	renewDone = make(chan struct{})
+)
+
+// catchUps 正在执行的补执行，停机时等待其结束
+var catchUps sync.WaitGroup
+
+// startLeaderElection tries to acquire the lock now and keeps renewing it in the background.
+// Every time this process becomes the leader, missed jobs are caught up
+func startLeaderElection() {
+	c := config.C()
+	ttl := c.Lock.TTL
+	if ttl <= 0 {
+		ttl = defaultLockTTL
+	}
+	var err error
+	locker, err = lock.New(c.Lock.Type, c.Lock.File, ttl)
+	if err != nil {
+		logrus.Error("Failed to create lock")
+		panic(err)
+	}
+
+	renew := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), ttl/3)
+		defer cancel()
+		held, err := locker.TryLock(ctx)
+		if err != nil {
+			logrus.Error("Failed to acquire lock: ", err)
+			held = false
+		}
+		select {
+		case <-stopRenew:
+			// 正在停机，不再开始执行任务
+			return
+		default:
+		}
+		if held && !isLeader.Load() {
+			logrus.Info("Acquired lock, this process runs scheduled jobs")
+			isLeader.Store(true)
+			catchUps.Add(1)
+			go func() {
+				defer catchUps.Done()
+				catchUpMissedJobs(context.Background())
+			}()
+		} else if !held && isLeader.Load() {
+			logrus.Warn("Lost lock, this process stops running scheduled jobs")
+			isLeader.Store(false)
+		}
+	}
+
+	renew()
+	if !isLeader.Load() {
+		logrus.Info("Lock is held by another process, scheduled jobs will not run here")
+	}
+	go func() {
+		defer close(renewDone)
+		ticker := time.NewTicker(ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				renew()
+			case <-stopRenew:
+				return
+			}
+		}
+	}()
+}
+
+// stopRenewing stops renewing the lock, so that no more catch-ups are started.
+// The returned channel is closed once the renewing goroutine has exited
+func stopRenewing() <-chan struct{} {
+	if locker == nil {
+		done := make(chan struct{})
+		close(done)
+		return done
+	}
+	close(stopRenew)
+	isLeader.Store(false)
+	return renewDone
+}
+
+// releaseLock releases the lock, so that another replica can take over at once
+func releaseLock() {
+	if locker == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), unlockTimeout)
+	defer cancel()
+	if err := locker.Unlock(ctx); err != nil {
+		logrus.Error("Failed to release lock: ", err)
+		return
+	}
+	logrus.Info("Released lock")
+}
@@ -0,0 +1,102 @@
+package controller
+
+import (
+	"context"
+	"time"
+	"xlab-feishu-robot/internal/store"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	departedStoreName = "departed_members"
+	pauseStoreName    = "reminder_pause"
+)
+
+// DepartedMember 已退群成员的归档数据
+type DepartedMember struct {
+	Name string    `json:"name"`
+	Left time.Time `json:"left"`
+	// 进群时间，成员仍处于新成员豁免期时才有
+	Joined *time.Time `json:"joined,omitempty"`
+	// 尚未过期的提醒确认
+	Acknowledgement *Acknowledgement `json:"acknowledgement,omitempty"`
+}
+
+// memberLeft removes the stored state of a member who left the knowledge tree group,
+// and archives it so that it can be looked up later
+func memberLeft(ctx context.Context, openId string, name string) {
+	archived := DepartedMember{Name: name, Left: time.Now()}
+
+	joined := make(newcomers)
+	err := store.Update(newcomersStoreName, &joined, func() bool {
+		at, ok := joined[openId]
+		if ok {
+			archived.Joined = &at
+			delete(joined, openId)
+		}
+		return ok
+	})
+	if err != nil {
+		logrus.WithContext(ctx).Error("Failed to save newcomers: ", err)
+	}
+
+	if ack, ok := forgetAcknowledgement(ctx, openId); ok {
+		archived.Acknowledgement = &ack
+	}
+
+	departed := make(map[string]DepartedMember)
+	err = store.Update(departedStoreName, &departed, func() bool {
+		departed[openId] = archived
+		return true
+	})
+	if err != nil {
+		logrus.WithContext(ctx).Error("Failed to save departed members: ", err)
+	}
+
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"open id": openId, "name": name}).Info("Archived state of member who left the group")
+	if isInWhiteList(openId) {
+		// 白名单在配置文件中，不自动修改
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"open id": openId, "name": name}).Warn("Member who left the group is still in whiteList, please remove it from config")
+	}
+}
+
+// ReminderPause 机器人被移出知识树群后暂停定时任务
+type ReminderPause struct {
+	Paused bool      `json:"paused"`
+	Since  time.Time `json:"since"`
+	ChatId string    `json:"chat_id"`
+}
+
+// logReminderPause warns at startup if reminders are paused
+func logReminderPause() {
+	if pause := loadReminderPause(context.Background()); pause.Paused {
+		logrus.Warn("Reminders are paused since ", pause.Since, ", because the robot was removed from the group")
+	}
+}
+
+// loadReminderPause reads the pause state from the store, so that every replica sees the same state
+func loadReminderPause(ctx context.Context) ReminderPause {
+	var pause ReminderPause
+	if err := store.Load(pauseStoreName, &pause); err != nil {
+		logrus.WithContext(ctx).Error("Failed to load reminder pause: ", err)
+	}
+	return pause
+}
+
+func setReminderPaused(ctx context.Context, paused bool, chatId string) {
+	pause := ReminderPause{Paused: paused, Since: time.Now(), ChatId: chatId}
+	if err := store.Save(pauseStoreName, pause); err != nil {
+		logrus.WithContext(ctx).Error("Failed to save reminder pause: ", err)
+	}
+	if paused {
+		logrus.WithContext(ctx).Warn("Robot was removed from the knowledge tree group, reminders are paused")
+	} else {
+		logrus.WithContext(ctx).Info("Robot was added to the knowledge tree group, reminders are resumed")
+	}
+}
+
+// remindersPaused reports whether scheduled jobs should be skipped
+func remindersPaused(ctx context.Context) bool {
+	return loadReminderPause(ctx).Paused
+}
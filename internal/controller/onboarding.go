@@ -0,0 +1,99 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	"xlab-feishu-robot/internal/config"
+	"xlab-feishu-robot/internal/pkg"
+	"xlab-feishu-robot/internal/store"
+
+	"github.com/YasyaKarasu/feishuapi"
+	"github.com/sirupsen/logrus"
+)
+
+const defaultWelcomeString = "欢迎加入知识树群！每个月我们每人需要维护至少一个知识树节点：" +
+	"在本月的表格中新增一条记录，填写维护人、维护节点链接和一句话介绍。" +
+	"每月15日和23日我会提醒还没有写的同学，月初会发送上个月的完成情况。本月是你加入的第一个月，不会被提醒。"
+
+const newcomersStoreName = "newcomers"
+
+// newcomers 新成员的open_id到进群时间的映射，进群当月不提醒
+// 每次使用时从store读取，多个副本看到的是同一份数据
+type newcomers map[string]time.Time
+
+// markNewcomer records that the member joined the group at t.
+// Entries older than two months are no longer needed and are dropped
+func markNewcomer(ctx context.Context, openId string, t time.Time) {
+	joined := make(newcomers)
+	err := store.Update(newcomersStoreName, &joined, func() bool {
+		joined[openId] = t
+		for id, at := range joined {
+			if t.Sub(at) > 62*24*time.Hour {
+				delete(joined, id)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		logrus.WithContext(ctx).Error("Failed to save newcomers: ", err)
+	}
+}
+
+// isNewcomer reports whether the member joined the group in the month of t,
+// in which case they are exempt from reminders and the monthly report for that month
+func isNewcomer(ctx context.Context, openId string, t time.Time) bool {
+	joined := make(newcomers)
+	if err := store.Load(newcomersStoreName, &joined); err != nil {
+		logrus.WithContext(ctx).Error("Failed to load newcomers: ", err)
+	}
+	at, ok := joined[openId]
+	return ok && at.Year() == t.Year() && at.Month() == t.Month()
+}
+
+// onboard marks a member who just joined the knowledge tree group as new and sends them a welcome message
+func onboard(ctx context.Context, openId string, name string) {
+	markNewcomer(ctx, openId, time.Now())
+	if !config.C().Onboarding.Enabled {
+		return
+	}
+	msg := welcomeMessage(ctx)
+	pkg.MessageEnqueue(ctx, feishuapi.UserOpenId, openId, feishuapi.Text, msg)
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"open id": openId, "name": name}).Info("Queued welcome message")
+}
+
+func welcomeMessage(ctx context.Context) string {
+	c := config.C()
+	var sb strings.Builder
+	if c.Onboarding.Welcome != "" {
+		sb.WriteString(c.Onboarding.Welcome)
+	} else {
+		sb.WriteString(defaultWelcomeString)
+	}
+	sb.WriteString("\n知识树维护链接：")
+	sb.WriteString(c.Info.KnowledgeTreeURL)
+	if example := exampleRecord(ctx); example != "" {
+		sb.WriteString("\n记录示例：")
+		sb.WriteString(example)
+	}
+	return sb.String()
+}
+
+// exampleRecord describes the first complete record of the latest table, or returns "" if there is none
+func exampleRecord(ctx context.Context) (example string) {
+	defer func() {
+		// 读取表格失败时不影响欢迎消息的发送
+		if err := recover(); err != nil {
+			logrus.WithContext(ctx).Warn("Failed to read example record: ", err)
+			example = ""
+		}
+	}()
+	for _, record := range getAllRecordsInTable(getLatestTable(ctx)) {
+		if len(record.NodeLink) == 0 || record.OneLineIntroduction == "" || len(record.Maintainers) == 0 {
+			continue
+		}
+		return fmt.Sprintf("维护人 %s，维护节点链接 %s，一句话介绍「%s」", record.Maintainers[0].Name, record.NodeLink[0].URL, record.OneLineIntroduction)
+	}
+	return ""
+}
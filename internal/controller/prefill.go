@@ -0,0 +1,96 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+	"xlab-feishu-robot/internal/config"
+	"xlab-feishu-robot/internal/model"
+	"xlab-feishu-robot/internal/pkg"
+	"xlab-feishu-robot/internal/util"
+
+	"github.com/YasyaKarasu/feishuapi"
+	"github.com/sirupsen/logrus"
+)
+
+// prefillCommand handles "@bot prefill" in the group, which inserts one placeholder record
+// for every group member into the month's table that the person in charge has just created
+func prefillCommand(ctx context.Context, messageevent *model.MessageEvent) {
+	chatId := messageevent.Message.Chat_id
+	if messageevent.Sender.Sender_id.Open_id != config.C().Info.PersonInChargeID {
+		pkg.MessageEnqueue(ctx, feishuapi.GroupChatId, chatId, feishuapi.Text, "只有知识树负责人可以初始化本月表格")
+		return
+	}
+
+	count, err := prefillTable(ctx, getLatestTable(ctx))
+	if err != nil {
+		logrus.WithContext(ctx).Error("Failed to prefill the monthly table: ", err)
+		pkg.MessageEnqueue(ctx, feishuapi.GroupChatId, chatId, feishuapi.Text, "初始化本月表格失败："+err.Error())
+		return
+	}
+	pkg.MessageEnqueue(ctx, feishuapi.GroupChatId, chatId, feishuapi.Text, fmt.Sprintf("已为%d位同学创建本月的维护记录，请在自己的记录中填写维护节点链接和一句话介绍", count))
+}
+
+// prefillTable inserts a record whose "维护人" is the member for every group member not in the white list,
+// leaving the link and introduction empty, so that everyone only has to fill in their own row.
+// Members who already have a record in the table are skipped, so it is safe to run more than once.
+// Returns the number of records created.
+func prefillTable(ctx context.Context, table feishuapi.TableInfo) (int, error) {
+	c := config.C()
+	now := time.Now()
+	allRecords := getAllRecordsInTable(table)
+
+	hasRecord := make(map[string]bool)
+	for _, record := range allRecords {
+		// 表格中已有其他月份的记录，说明本月的表格还没有创建
+		recordYear, recordMonth := util.ParseTimestamp(record.TimeStamp)
+		if recordYear != now.Year() || recordMonth != int(now.Month()) {
+			return 0, fmt.Errorf("latest table %q is not this month's table", table.Name)
+		}
+		for _, maintainer := range record.Maintainers {
+			hasRecord[maintainer.ID] = true
+		}
+	}
+
+	count, failed := 0, 0
+	allMembers := pkg.Cli.GroupGetMembers(c.Info.GroupID, feishuapi.OpenId)
+	for _, member := range allMembers {
+		if hasRecord[member.MemberId] || isInWhiteList(member.MemberId) {
+			continue
+		}
+		if c.DryRun() {
+			count++
+			logrus.WithContext(ctx).WithFields(logrus.Fields{"table": table.Name, "member": member.Name}).Info("Dry-run mode, record not created")
+			continue
+		}
+		if err := createPlaceholderRecord(table, member.MemberId); err != nil {
+			failed++
+			logrus.WithContext(ctx).WithFields(logrus.Fields{"table": table.Name, "member": member.Name}).Error("Failed to create placeholder record: ", err)
+			continue
+		}
+		count++
+	}
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"table": table.Name, "count": count, "failed": failed}).Info("Prefilled the monthly table")
+	if count == 0 && failed > 0 {
+		return 0, fmt.Errorf("failed to create %d records", failed)
+	}
+	return count, nil
+}
+
+// createPlaceholderRecord creates the member's empty record. The feishu api client panics
+// instead of returning an error when the request fails, the panic is turned into an error
+func createPlaceholderRecord(table feishuapi.TableInfo, memberId string) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	record := pkg.Cli.DocumentCreateRecord(table.AppToken, table.TableId, map[string]any{
+		"维护人": []feishuapi.FieldStaff{{ID: memberId}},
+	})
+	if record == nil || record.RecordId == "" {
+		return errors.New("no record returned")
+	}
+	return nil
+}
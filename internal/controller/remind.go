@@ -1,7 +1,11 @@
 package controller
 
 import (
+	"context"
+	"fmt"
+	"reflect"
 	"strings"
+	"time"
 	"xlab-feishu-robot/internal/config"
 	"xlab-feishu-robot/internal/model"
 	"xlab-feishu-robot/internal/pkg"
@@ -13,57 +17,165 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// 每月1日提醒的默认文案，可在配置remind中修改
 const (
-	remindPersonInChargeString    = "请及时创建本月的维护记录"
-	remindGroupMembersStartString = "请及时开始写本月的知识树文档"
+	defaultRemindPersonInChargeString    = "请及时创建本月的维护记录，创建表格后可以在群里@我并发送 prefill，为每位同学预先插入一条记录"
+	defaultRemindGroupMembersStartString = "请及时开始写本月的知识树文档"
 )
 
 func Remind() {
-	cronTimer := cron.New()
-	// Remind the person in charge to create maintenance record
-	// Remind group members to start writing knowledge tree documents
-	// every month on the 1st at 10:00
-	_, err := cronTimer.AddFunc("0 10 1 * *", func() {
-		remindFirstDay()
-	})
+	c := config.C()
+	logReminderPause()
+
+	warnUnknownJobs(c)
+	timer, entries, err := newScheduler(c)
 	if err != nil {
 		logrus.Error("Failed to add cron job")
 		panic(err)
 	}
-	logrus.Info("Added cron job on the 1st of every month at 10:00")
+	for _, j := range jobs {
+		logrus.Info("Added cron job to ", j.Description)
+	}
 
-	// Every 15th/23rd of the month at 10:00, check who has not written the knowledge tree document
-	_, err = cronTimer.AddFunc("0 10 15,23 * *", func() {
-		sendRemindMessage()
-	})
-	if err != nil {
-		logrus.Error("Failed to add cron job")
-		panic(err)
+	logrus.Info("Add jobs successfully, going to start cron timer")
+	cronMu.Lock()
+	cronTimer, jobEntries = timer, entries
+	cronTimer.Start()
+	cronRunning.Store(true)
+	cronMu.Unlock()
+	config.OnChange(reschedule)
+
+	// missed jobs are caught up once this process holds the lock
+	startLeaderElection()
+}
+
+// Stop stops the cron timer and waits for running jobs and catch-ups to finish, or until ctx is done.
+// The lock is released in either case
+func Stop(ctx context.Context) error {
+	cronMu.Lock()
+	timer := cronTimer
+	retired := retiredTimers
+	cronRunning.Store(false)
+	cronMu.Unlock()
+	if timer == nil {
+		return nil
 	}
+	defer releaseLock()
 
-	// Every 1st of the month at 0:00, send last month's monthly report
-	_, err = cronTimer.AddFunc("@monthly", func() {
-		sendMonthlyReport()
-	})
-	if err != nil {
-		logrus.Error("Failed to add cron job")
-		panic(err)
+	logrus.Info("Stopping cron timer, waiting for running jobs")
+	timerStopped := timer.Stop()
+	renewStopped := stopRenewing()
+	done := make(chan struct{})
+	go func() {
+		<-timerStopped.Done()
+		// 重新调度前的调度器上可能还有任务在执行
+		for _, stopped := range retired {
+			<-stopped.Done()
+		}
+		// 续约停止后不会再开始新的补执行
+		<-renewStopped
+		catchUps.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		logrus.Info("Cron timer stopped")
+		return nil
+	case <-ctx.Done():
+		logrus.Warn("Timeout waiting for running jobs")
+		return ctx.Err()
 	}
+}
 
-	logrus.Info("Add jobs successfully, going to start cron timer")
+// newScheduler creates a cron timer with an entry for every job, using the specs and timezone in c
+func newScheduler(c *config.Config) (*cron.Cron, map[string]cron.EntryID, error) {
+	timer := cron.New(cron.WithLocation(c.Location()))
+	entries := make(map[string]cron.EntryID)
+	for _, j := range jobs {
+		j := j
+		id, err := timer.AddFunc(j.spec(c), func() {
+			if !isLeader.Load() {
+				logrus.WithField("job", j.Name).Info("Not holding the lock, skip job")
+				return
+			}
+			if remindersPaused(context.Background()) {
+				logrus.WithField("job", j.Name).Warn("Reminders are paused, skip job")
+				return
+			}
+			runJob(j, TriggerCron, &jobRun{})
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("job %s: %w", j.Name, err)
+		}
+		entries[j.Name] = id
+	}
+	return timer, entries, nil
+}
+
+// reschedule replaces the cron timer when the schedule in config changes.
+// Jobs running on the old timer are left to finish, and are waited for by Stop
+func reschedule(old *config.Config, new *config.Config) {
+	if old.Schedule.Timezone == new.Schedule.Timezone && reflect.DeepEqual(old.Schedule.Jobs, new.Schedule.Jobs) {
+		return
+	}
+	warnUnknownJobs(new)
+
+	cronMu.Lock()
+	defer cronMu.Unlock()
+	if !cronRunning.Load() {
+		return
+	}
+	timer, entries, err := newScheduler(new)
+	if err != nil {
+		logrus.Error("Failed to reschedule jobs, keeping the current schedule: ", err)
+		return
+	}
+	// 只保留还有任务在执行的旧调度器
+	running := retiredTimers[:0]
+	for _, stopped := range retiredTimers {
+		if stopped.Err() == nil {
+			running = append(running, stopped)
+		}
+	}
+	retiredTimers = append(running, cronTimer.Stop())
+	cronTimer, jobEntries = timer, entries
 	cronTimer.Start()
+	for _, j := range jobs {
+		logrus.WithFields(logrus.Fields{"job": j.Name, "spec": j.spec(new), "timezone": new.Location()}).Info("Rescheduled job")
+	}
+}
+
+// warnUnknownJobs warns about names in schedule.jobs that are not jobs
+func warnUnknownJobs(c *config.Config) {
+	for name := range c.Schedule.Jobs {
+		known := false
+		for _, j := range jobs {
+			known = known || strings.EqualFold(name, j.Name)
+		}
+		if !known {
+			logrus.Warn("Unknown job in schedule.jobs, ignored: ", name)
+		}
+	}
 }
 
-func sendToGroup(str string) {
-	pkg.Cli.MessageSend(feishuapi.GroupChatId, config.C.Info.GroupID, feishuapi.Text, str)
+func (r *jobRun) sendToGroup(str string) string {
+	return r.send(feishuapi.GroupChatId, config.C().Info.GroupID, str)
 }
 
-func remindFirstDay() {
-	pkg.Cli.MessageSend(feishuapi.UserOpenId, config.C.Info.PersonInChargeID, feishuapi.Text, remindPersonInChargeString)
-	sendToGroup(remindGroupMembersStartString)
+func remindFirstDay(run *jobRun) {
+	c := config.C()
+	personInCharge, groupMembers := c.Remind.PersonInCharge, c.Remind.GroupMembers
+	if personInCharge == "" {
+		personInCharge = defaultRemindPersonInChargeString
+	}
+	if groupMembers == "" {
+		groupMembers = defaultRemindGroupMembersStartString
+	}
+	run.send(feishuapi.UserOpenId, c.Info.PersonInChargeID, personInCharge)
+	run.send(feishuapi.GroupChatId, c.Info.GroupID, groupMembers)
 }
 
-func remindNotWritten(personsNotWritten []feishuapi.GroupMember) {
+func remindNotWritten(run *jobRun, personsNotWritten []feishuapi.GroupMember) {
 	var sb strings.Builder
 	sb.WriteString("滴滴！查询知识树进度：\n")
 	for _, person := range personsNotWritten {
@@ -71,71 +183,97 @@ func remindNotWritten(personsNotWritten []feishuapi.GroupMember) {
 		sb.WriteString("<at user_id=\"" + person.MemberId + "\">" + person.Name + "</at>")
 	}
 	sb.WriteString(" \n知识树维护链接：")
-	sb.WriteString(config.C.Info.KnowledgeTreeURL)
-	logrus.Info("Remind message: ", sb.String())
-	sendToGroup(sb.String())
+	sb.WriteString(config.C().Info.KnowledgeTreeURL)
+	run.logger().Info("Remind message: ", sb.String())
+	if messageId := run.sendToGroup(sb.String()); messageId != "" {
+		recordReminder(run.ctx, messageId, personsNotWritten)
+	}
 }
 
 // sendMonthlyReport sends monthly report
-func sendMonthlyReport() {
+func sendMonthlyReport(run *jobRun) {
 	// Get the persons who did not write the knowledge tree document
-	personsNotWritten := getPersonsNotWritten()
+	// The report is scheduled at 0:00 on the 1st, so it is about the month before the scheduled day
+	personsNotWritten := getPersonsNotWritten(run.ctx, run.Scheduled.AddDate(0, 0, -1))
 	if len(personsNotWritten) > 0 {
-		reportNotWritten(personsNotWritten)
+		reportNotWritten(run, personsNotWritten)
 	} else {
-		reportAllWritten()
+		reportAllWritten(run)
 	}
+	sendMonthlySummary(run)
 }
 
 // reportNotWritten sends monthly report when some group members have not written the knowledge tree document
-func reportNotWritten(personsNotWritten []feishuapi.GroupMember) {
+func reportNotWritten(run *jobRun, personsNotWritten []feishuapi.GroupMember) {
 	var sb strings.Builder
 	sb.WriteString("滴滴！本月未完成知识树的同学：\n")
 	for _, person := range personsNotWritten {
 		// @ person in the format of <at user_id="xxx">xxx</at>
 		sb.WriteString("<at user_id=\"" + person.MemberId + "\">" + person.Name + "</at>")
 	}
-	logrus.Info("Monthly report: ", sb.String())
-	sendToGroup(sb.String())
+	run.logger().Info("Monthly report: ", sb.String())
+	run.sendToGroup(sb.String())
 }
 
 // reportAllWritten sends monthly report when all group members have written the knowledge tree document
-func reportAllWritten() {
+func reportAllWritten(run *jobRun) {
 	var sb strings.Builder
 	sb.WriteString("滴滴！本月知识树文档已全部完成。\n")
-	sendToGroup(sb.String())
+	run.sendToGroup(sb.String())
 }
 
-func sendRemindMessage() {
-	personsNotWritten := getPersonsNotWritten()
-	if len(personsNotWritten) > 0 {
-		remindNotWritten(personsNotWritten)
+func sendRemindMessage(run *jobRun) {
+	now := run.Scheduled
+	personsNotWritten := getPersonsNotWritten(run.ctx, now)
+	if len(personsNotWritten) == 0 {
+		run.logger().Info("All group members have written the knowledge tree document")
+		return
+	}
+
+	// Do not @ the persons who acknowledged a previous reminder recently
+	personsToRemind := make([]feishuapi.GroupMember, 0, len(personsNotWritten))
+	for _, person := range personsNotWritten {
+		if isAcknowledged(run.ctx, person.MemberId, now) {
+			run.logger().WithField("name", person.Name).Info("Person acknowledged a reminder, skip")
+			continue
+		}
+		personsToRemind = append(personsToRemind, person)
+	}
+	if len(personsToRemind) > 0 {
+		remindNotWritten(run, personsToRemind)
 	} else {
-		logrus.Info("All group members have written the knowledge tree document")
+		run.logger().Info("All persons who have not written the knowledge tree document acknowledged a reminder")
 	}
 }
 
-// getPersonsNotWritten gets persons who have not written the knowledge tree document
-func getPersonsNotWritten() []feishuapi.GroupMember {
+// getPersonsNotWritten gets persons who have not written the knowledge tree document in the month of t
+// Members who joined the group in the month of t are exempt
+func getPersonsNotWritten(ctx context.Context, t time.Time) []feishuapi.GroupMember {
 	result := make([]feishuapi.GroupMember, 0)
-	allMembers := pkg.Cli.GroupGetMembers(config.C.Info.GroupID, feishuapi.OpenId)
+	allMembers := pkg.Cli.GroupGetMembers(config.C().Info.GroupID, feishuapi.OpenId)
 
-	personsWritten := getPersonWritten()
+	personsWritten := getPersonWritten(ctx, t.Year(), int(t.Month()))
 	for _, member := range allMembers {
-		if _, ok := personsWritten[member.MemberId]; !ok && !isInWhiteList(member.MemberId) {
-			// If the member is not in the white list and has not written the knowledge tree document
+		if _, ok := personsWritten[member.MemberId]; !ok && !isInWhiteList(member.MemberId) && !isNewcomer(ctx, member.MemberId, t) {
+			// If the member is not in the white list, is not new and has not written the knowledge tree document
 			// Add the member to the result
 			result = append(result, member)
 		}
 	}
-	logrus.Info("Persons who have not written the knowledge tree document: ", result)
+	logrus.WithContext(ctx).Info("Persons who have not written the knowledge tree document: ", result)
 	return result
 }
 
-// getPersonWritten get the persons who have written the knowledge tree document, store in a map
-func getPersonWritten() map[string]bool {
+// getPersonWritten get the persons who have written the knowledge tree document in the month, store in a map
+func getPersonWritten(ctx context.Context, year int, month int) map[string]bool {
 	result := make(map[string]bool)
-	allRecords := getAllRecordsInTable(getLatestTable())
+	table := getTableByTime(ctx, year, month)
+	if table.TableId == "" {
+		// 本月的表格还没有创建，所有人都还没写
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"year": year, "month": month}).Warn("No table found for the month")
+		return result
+	}
+	allRecords := getAllRecordsInTable(table)
 	for _, record := range allRecords {
 		// 该记录的维护节点链接必须非空，否则不算写了知识树
 		if record.NodeLink != nil {
@@ -147,32 +285,33 @@ func getPersonWritten() map[string]bool {
 			}
 		}
 	}
-	logrus.Info("Persons who have written the knowledge tree document: ", result)
+	logrus.WithContext(ctx).Info("Persons who have written the knowledge tree document: ", result)
 	return result
 }
 
-func getAllTables() []feishuapi.TableInfo {
+func getAllTables(ctx context.Context) []feishuapi.TableInfo {
 	// 注意：DocumentGetAllBitables返回的数组中的所有bitable.AppToken是一样的
 	// 所以这里直接取第一个bitable的AppToken
-	bitable := pkg.Cli.DocumentGetAllBitables(getKnowledgeTreeDocumentID())[0]
+	bitable := pkg.Cli.DocumentGetAllBitables(getKnowledgeTreeDocumentID(ctx))[0]
 	// bitable里面的所有table相当于知识树文档中的所有表格
 	return pkg.Cli.DocumentGetAllTables(bitable.AppToken)
 }
 
-func getLatestTable() feishuapi.TableInfo {
+func getLatestTable(ctx context.Context) feishuapi.TableInfo {
 	// 最新表格在数组的第一个位置
-	return getAllTables()[0]
+	return getAllTables(ctx)[0]
 }
 
-func getKnowledgeTreeDocumentID() string {
-	logrus.Info("Node token: ", config.C.Info.NodeToken)
-	nodeInfo := pkg.Cli.KnowledgeSpaceGetNodeInfo(config.C.Info.NodeToken)
+func getKnowledgeTreeDocumentID(ctx context.Context) string {
+	c := config.C()
+	logrus.WithContext(ctx).Info("Node token: ", c.Info.NodeToken)
+	nodeInfo := pkg.Cli.KnowledgeSpaceGetNodeInfo(c.Info.NodeToken)
 	return nodeInfo.ObjToken
 }
 
 // 判断是否在白名单中
 func isInWhiteList(person string) bool {
-	for _, p := range config.C.WhiteList {
+	for _, p := range config.C().WhiteList {
 		if p == person {
 			return true
 		}
@@ -189,9 +328,9 @@ func getAllRecordsInTable(table feishuapi.TableInfo) []model.Record {
 	return result
 }
 
-func getTableByTime(year int, month int) feishuapi.TableInfo {
+func getTableByTime(ctx context.Context, year int, month int) feishuapi.TableInfo {
 	// 获取所有表格
-	allTables := getAllTables()
+	allTables := getAllTables(ctx)
 	for _, table := range allTables {
 		// 获取表格中的所有记录
 		allRecords := getAllRecordsInTable(table)
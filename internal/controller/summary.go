@@ -0,0 +1,213 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"xlab-feishu-robot/internal/config"
+	"xlab-feishu-robot/internal/model"
+	"xlab-feishu-robot/internal/pkg"
+
+	"github.com/YasyaKarasu/feishuapi"
+	"github.com/sirupsen/logrus"
+)
+
+// maintainerRecords 某位维护人在一个月内的所有记录
+type maintainerRecords struct {
+	Maintainer model.Maintainer
+	Records    []model.Record
+}
+
+// groupByMaintainer groups records by maintainer, keeping the order in which maintainers first appear.
+// A record with several maintainers is listed under each of them
+func groupByMaintainer(records []model.Record) []maintainerRecords {
+	result := make([]maintainerRecords, 0)
+	index := make(map[string]int)
+	for _, record := range records {
+		maintainers := record.Maintainers
+		if len(maintainers) == 0 {
+			maintainers = []model.Maintainer{{Name: "未填写维护人"}}
+		}
+		for _, maintainer := range maintainers {
+			i, ok := index[maintainer.ID+maintainer.Name]
+			if !ok {
+				i = len(result)
+				index[maintainer.ID+maintainer.Name] = i
+				result = append(result, maintainerRecords{Maintainer: maintainer})
+			}
+			result[i].Records = append(result[i].Records, record)
+		}
+	}
+	return result
+}
+
+// renderSummaryMarkdown renders the records of a month as a Markdown document
+func renderSummaryMarkdown(year int, month int, records []model.Record) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("# %d年%d月知识树汇总\n\n", year, month))
+	sb.WriteString(fmt.Sprintf("共 %d 条记录。\n", len(records)))
+	for _, group := range groupByMaintainer(records) {
+		sb.WriteString(fmt.Sprintf("\n## %s\n\n", group.Maintainer.Name))
+		for _, record := range group.Records {
+			intro := record.OneLineIntroduction
+			if intro == "" {
+				intro = "（未填写一句话介绍）"
+			}
+			sb.WriteString(fmt.Sprintf("- %s（👍 %d）\n", intro, record.LikeCount))
+			for _, link := range record.NodeLink {
+				sb.WriteString(fmt.Sprintf("  - [%s](%s)\n", link.Text, link.URL))
+			}
+		}
+	}
+	return sb.String()
+}
+
+// sendMonthlySummary generates the summary document of the month before the scheduled day and posts its link to the group
+func sendMonthlySummary(run *jobRun) {
+	lastMonth := run.Scheduled.AddDate(0, 0, -1)
+	year, month := lastMonth.Year(), int(lastMonth.Month())
+	table := getTableByTime(run.ctx, year, month)
+	if table.TableId == "" {
+		run.logger().WithFields(logrus.Fields{"year": year, "month": month}).Warn("No table found for the month, skip the monthly summary")
+		return
+	}
+	records := writtenRecords(getAllRecordsInTable(table))
+
+	if run.DryRun {
+		run.sendToGroup(fmt.Sprintf("%d年%d月知识树汇总文档：（dry run，未生成文档）", year, month))
+		return
+	}
+
+	if !archiveConfigured() {
+		// 未配置归档空间时只写Markdown文件，不算失败
+		writeSummaryFile(run.ctx, year, month, records)
+		return
+	}
+	link, err := createSummaryDocument(year, month, records)
+	if err != nil {
+		run.logger().Warn("Failed to create summary document in knowledge space, falling back to Markdown file: ", err)
+		writeSummaryFile(run.ctx, year, month, records)
+		return
+	}
+	run.logger().Info("Monthly summary document created: ", link)
+	run.sendToGroup(fmt.Sprintf("%d年%d月知识树汇总文档：%s", year, month, link))
+}
+
+// writtenRecords drops the records without a node link, such as the placeholders inserted by prefill
+func writtenRecords(records []model.Record) []model.Record {
+	result := make([]model.Record, 0, len(records))
+	for _, record := range records {
+		if len(record.NodeLink) > 0 {
+			result = append(result, record)
+		}
+	}
+	return result
+}
+
+func archiveConfigured() bool {
+	archive := config.C().Archive
+	return archive.SpaceID != "" && archive.ParentNodeToken != ""
+}
+
+func writeSummaryFile(ctx context.Context, year int, month int, records []model.Record) {
+	path, err := writeSummaryMarkdown(year, month, records)
+	if err != nil {
+		logrus.WithContext(ctx).Error("Failed to write summary Markdown file: ", err)
+		return
+	}
+	logrus.WithContext(ctx).Info("Monthly summary written to ", path)
+}
+
+// writeSummaryMarkdown writes the summary as a Markdown file in the archive directory, returns the file path
+func writeSummaryMarkdown(year int, month int, records []model.Record) (string, error) {
+	dir := config.C().Archive.Dir
+	if dir == "" {
+		dir = "./archive"
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("%d-%02d.md", year, month))
+	return path, os.WriteFile(path, []byte(renderSummaryMarkdown(year, month, records)), 0644)
+}
+
+// createSummaryDocument creates a docx node in the knowledge space and fills it with the summary,
+// returns the url of the new node. A panic of the feishu api client on a malformed response
+// is turned into an error, so that the caller falls back to the Markdown file
+func createSummaryDocument(year int, month int, records []model.Record) (link string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			link, err = "", fmt.Errorf("panic: %v", r)
+		}
+	}()
+	c := config.C()
+	archive := c.Archive
+	if archive.SpaceID == "" || archive.ParentNodeToken == "" {
+		return "", fmt.Errorf("archive space is not configured")
+	}
+	if c.DryRun() {
+		return "", fmt.Errorf("documents are not created in dry-run mode")
+	}
+
+	resp := pkg.Cli.Request("post", "open-apis/wiki/v2/spaces/"+archive.SpaceID+"/nodes", nil, nil, map[string]string{
+		"obj_type":          "docx",
+		"node_type":         "origin",
+		"parent_node_token": archive.ParentNodeToken,
+		"title":             fmt.Sprintf("%d年%d月知识树汇总", year, month),
+	})
+	if resp == nil {
+		return "", fmt.Errorf("failed to create node under %s", archive.ParentNodeToken)
+	}
+	nodeResp, ok := resp["node"].(map[string]any)
+	if !ok {
+		return "", fmt.Errorf("no node returned when creating node under %s", archive.ParentNodeToken)
+	}
+	node := feishuapi.NewNodeInfo(nodeResp)
+	if node == nil || node.ObjToken == "" || node.NodeToken == "" {
+		return "", fmt.Errorf("node created under %s has no document", archive.ParentNodeToken)
+	}
+
+	// 文档的根block id与文档id相同
+	resp = pkg.Cli.Request("post", "open-apis/docx/v1/documents/"+node.ObjToken+"/blocks/"+node.ObjToken+"/children", nil, nil, map[string]any{
+		"children": summaryBlocks(records),
+		"index":    0,
+	})
+	if resp == nil {
+		return "", fmt.Errorf("failed to write summary into document %s", node.ObjToken)
+	}
+	return strings.TrimSuffix(archive.URLPrefix, "/") + "/" + node.NodeToken, nil
+}
+
+// summaryBlocks builds docx text blocks with the same content as renderSummaryMarkdown
+func summaryBlocks(records []model.Record) []map[string]any {
+	textRun := func(content string, style map[string]any) map[string]any {
+		run := map[string]any{"content": content}
+		if style != nil {
+			run["text_element_style"] = style
+		}
+		return map[string]any{"text_run": run}
+	}
+	textBlock := func(elements ...map[string]any) map[string]any {
+		return map[string]any{"block_type": 2, "text": map[string]any{"elements": elements}}
+	}
+
+	blocks := []map[string]any{textBlock(textRun(fmt.Sprintf("共 %d 条记录。", len(records)), nil))}
+	for _, group := range groupByMaintainer(records) {
+		blocks = append(blocks, textBlock(textRun(group.Maintainer.Name, map[string]any{"bold": true})))
+		for _, record := range group.Records {
+			intro := record.OneLineIntroduction
+			if intro == "" {
+				intro = "（未填写一句话介绍）"
+			}
+			elements := []map[string]any{textRun(fmt.Sprintf("%s（👍 %d）", intro, record.LikeCount), nil)}
+			for _, link := range record.NodeLink {
+				elements = append(elements, textRun(" ", nil), textRun(link.Text, map[string]any{"link": map[string]any{"url": url.QueryEscape(link.URL)}}))
+			}
+			blocks = append(blocks, textBlock(elements...))
+		}
+	}
+	return blocks
+}
@@ -1,20 +1,48 @@
 package dispatcher
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
-	"io/ioutil"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"xlab-feishu-robot/internal/config"
+	"xlab-feishu-robot/internal/metrics"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 )
 
+// defaultMaxBodySize 未配置dispatcher.maxBodySize时请求体的大小上限
+const defaultMaxBodySize = 1 << 20
+
+// 请求的处理结果，用于日志和metrics
+const (
+	outcomeDispatched   = "dispatched"
+	outcomeChallenge    = "challenge"
+	outcomeDuplicate    = "duplicate"
+	outcomeNoHandler    = "no_handler"
+	outcomeQueueFull    = "queue_full"
+	outcomeStopping     = "stopping"
+	outcomeTooLarge     = "too_large"
+	outcomeBadRequest   = "bad_request"
+	outcomeUnauthorized = "unauthorized"
+)
+
 // @Summary feishu event dispatcher
+// @Description Errors are returned as {"error": "...", "request_id": "..."}; repeated events are answered with 200 so that feishu stops retrying
 // @Tags feishu_event
 // @Accept json
+// @Produce json
 // @Success 200 {string} OK
-// @Router /feishu_events [post]
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 413 {object} map[string]string
+// @Failure 503 {object} map[string]string
+// @Router /feiShu/Event [post]
 func Dispatcher(c *gin.Context) {
 	// Handler for Feishu Event Http Callback
 
@@ -26,72 +54,136 @@ func Dispatcher(c *gin.Context) {
 
 	// see: https://open.feishu.cn/document/ukTMukTMukTM/uUTNz4SN1MjL1UzM
 
-	// get raw body (bytes)
-	rawBody, _ := ioutil.ReadAll(c.Request.Body)
+	requestId := c.GetHeader("X-Request-Id")
+	if requestId == "" {
+		requestId = newRequestId()
+	}
+	c.Header("X-Request-Id", requestId)
+	log := logrus.WithField("request id", requestId)
 
-	// decrypt data if ENCRYPT is on
-	var requestStr string
-	if encryptKey := config.C.Feishu.EncryptKey; encryptKey != "" {
-		rawBodyJson := make(map[string]any)
-		json.Unmarshal(rawBody, &rawBodyJson)
-		rawRequestStr, _ := rawBodyJson["encrypt"].(string)
-		var err error
-		requestStr, err = decrypt(rawRequestStr, encryptKey)
-		if err != nil {
-			logrus.Error("Cannot decrypt request")
+	fail := func(status int, outcome string, err error) {
+		metrics.DispatcherRequests.WithLabelValues(outcome).Inc()
+		log.WithFields(logrus.Fields{"outcome": outcome, "error": err}).Warn("Reject feishu event request")
+		c.JSON(status, gin.H{"error": err.Error(), "request_id": requestId})
+	}
+
+	// get raw body (bytes), at most dispatcher.maxBodySize
+	maxBodySize := config.C().Dispatcher.MaxBodySize
+	if maxBodySize <= 0 {
+		maxBodySize = defaultMaxBodySize
+	}
+	rawBody, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxBodySize))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			fail(http.StatusRequestEntityTooLarge, outcomeTooLarge, fmt.Errorf("request body larger than %d bytes", maxBodySize))
+			return
 		}
-	} else {
-		requestStr = string(rawBody)
+		fail(http.StatusBadRequest, outcomeBadRequest, fmt.Errorf("read request body: %w", err))
+		return
+	}
+
+	// decrypt data if ENCRYPT is on
+	requestStr, err := decryptBody(rawBody)
+	if err != nil {
+		fail(http.StatusBadRequest, outcomeBadRequest, err)
+		return
 	}
 
 	var req FeishuEventRequest
-	deserializeRequest(requestStr, &req)
-	logrus.Debug("Feishu Robot received a request: ", req)
+	if err := deserializeRequest(requestStr, &req); err != nil {
+		fail(http.StatusBadRequest, outcomeBadRequest, err)
+		return
+	}
+	log.Debug("Feishu Robot received a request: ", req)
 
 	// return to server test event
 	if req.Challenge != "" {
+		metrics.DispatcherRequests.WithLabelValues(outcomeChallenge).Inc()
 		c.JSON(http.StatusOK, gin.H{"challenge": req.Challenge})
 		return
 	}
 
-	if !validateRequest(c, req.Token, string(rawBody)) {
-		logrus.Error("Cannot validate event: ", req)
-		c.String(http.StatusBadRequest, "验证错误")
-		return
-	}
-
-	if eventRepeatDetect(req.EventId) {
-		logrus.Warning("Repeated event: ", req)
-		c.String(http.StatusBadRequest, "事件重复")
+	if err := validateRequest(c, req.Token, string(rawBody)); err != nil {
+		metrics.ValidationFailures.WithLabelValues(validationReasons[err]).Inc()
+		fail(http.StatusUnauthorized, outcomeUnauthorized, err)
 		return
 	}
 
-	if handler, exists := eventMap[req.EventType]; exists {
-		c.String(http.StatusOK, "OK")
-		go handler(req.Event)
-		return
-	} else {
-		logrus.Warn("Failed to find event handler: ", req)
+	switch outcome := dispatch(req); outcome {
+	case outcomeQueueFull:
+		fail(http.StatusServiceUnavailable, outcome, errors.New("event queue is full"))
+	case outcomeStopping:
+		fail(http.StatusServiceUnavailable, outcome, errors.New("robot is shutting down"))
+	case outcomeDuplicate:
+		// return status ok to avoid retry
+		metrics.DispatcherRequests.WithLabelValues(outcome).Inc()
+		c.String(http.StatusOK, "事件重复")
+	case outcomeNoHandler:
 		// return status ok to avoid retry
+		metrics.DispatcherRequests.WithLabelValues(outcome).Inc()
 		c.String(http.StatusOK, "无对应处理函数")
-		return
+	default:
+		metrics.DispatcherRequests.WithLabelValues(outcome).Inc()
+		c.String(http.StatusOK, "OK")
 	}
 }
 
-func validateRequest(c *gin.Context, token string, rawBodyStr string) bool {
-	// check the token and hash in event request header
+// decryptBody returns the request json, decrypting the body if an encrypt key is configured
+func decryptBody(rawBody []byte) (string, error) {
+	encryptKey := config.C().Feishu.EncryptKey
+	if encryptKey == "" {
+		return string(rawBody), nil
+	}
+	var rawBodyJson struct {
+		Encrypt string `json:"encrypt"`
+	}
+	if err := json.Unmarshal(rawBody, &rawBodyJson); err != nil {
+		return "", fmt.Errorf("malformed request body: %w", err)
+	}
+	if rawBodyJson.Encrypt == "" {
+		return "", errors.New("missing encrypt field in request body")
+	}
+	requestStr, err := decrypt(rawBodyJson.Encrypt, encryptKey)
+	if err != nil {
+		return "", fmt.Errorf("cannot decrypt request: %w", err)
+	}
+	return requestStr, nil
+}
 
-	if token != config.C.Feishu.VerificationToken {
-		return false
+// dispatch puts a validated event into the worker queue, and returns the outcome
+func dispatch(req FeishuEventRequest) string {
+	intake.RLock()
+	defer intake.RUnlock()
+	if intake.closed {
+		logrus.Warn("Robot is shutting down, reject event: ", req)
+		return outcomeStopping
+	}
+
+	metrics.EventsReceived.WithLabelValues(req.EventType).Inc()
+	if eventRepeatDetect(req.EventId) {
+		metrics.EventsDuplicate.WithLabelValues(req.EventType).Inc()
+		logrus.Warning("Repeated event: ", req)
+		return outcomeDuplicate
 	}
-	timestamp := c.Request.Header.Get("X-Lark-Request-Timestamp")
-	nonce := c.Request.Header.Get("X-Lark-Request-Nonce")
-	signature := c.Request.Header.Get("X-Lark-Signature")
 
-	return signature == calculateSignature(timestamp, nonce, config.C.Feishu.EncryptKey, rawBodyStr)
+	handler, exists := eventMap[req.EventType]
+	if !exists {
+		logrus.Warn("Failed to find event handler: ", req)
+		return outcomeNoHandler
+	}
+	if !enqueue(task{event: req.envelope(), handler: handler}) {
+		logrus.Warn("Event queue is full: ", req)
+		// forget the event so that the retry from feishu is not treated as repeated
+		eventForget(req.EventId)
+		return outcomeQueueFull
+	}
+	return outcomeDispatched
 }
 
 func eventRepeatDetect(eventId string) bool {
+	eventIdMu.Lock()
+	defer eventIdMu.Unlock()
 	if _, repeated := eventIdList[eventId]; repeated {
 		return true
 	} else {
@@ -99,3 +191,40 @@ func eventRepeatDetect(eventId string) bool {
 		return false
 	}
 }
+
+func eventForget(eventId string) {
+	eventIdMu.Lock()
+	defer eventIdMu.Unlock()
+	delete(eventIdList, eventId)
+}
+
+func newRequestId() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// StopIntake stops accepting events from both transports; feishu delivers the rejected events again later.
+// It must be called before Wait, so that no handler is added while waiting
+func StopIntake() {
+	intake.Lock()
+	intake.closed = true
+	intake.Unlock()
+	stopLongConnection()
+}
+
+// Wait waits for all running event handlers to return, or until ctx is done.
+// StopIntake must have been called
+func Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		handlers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
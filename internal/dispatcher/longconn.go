@@ -0,0 +1,105 @@
+package dispatcher
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"xlab-feishu-robot/internal/config"
+	"xlab-feishu-robot/internal/metrics"
+
+	larkcore "github.com/larksuite/oapi-sdk-go/v3/core"
+	larkevent "github.com/larksuite/oapi-sdk-go/v3/event"
+	larkdispatcher "github.com/larksuite/oapi-sdk-go/v3/event/dispatcher"
+	larkws "github.com/larksuite/oapi-sdk-go/v3/ws"
+	"github.com/sirupsen/logrus"
+)
+
+// stopLongConnection 取消长连接客户端的context，未使用长连接时为空操作
+var stopLongConnection = func() {}
+
+// StartLongConnection receives events over feishu's long connection (WebSocket) instead of http callbacks.
+// Every event type registered so far is subscribed, so it must be called after all handlers are registered.
+// The connection reconnects by itself until StopIntake cancels its context. The client offers no way to
+// close the socket, so it stays open until the process exits, but events received after StopIntake are rejected
+func StartLongConnection() {
+	c := config.C()
+	eventDispatcher := larkdispatcher.NewEventDispatcher(c.Feishu.VerificationToken, c.Feishu.EncryptKey)
+	for eventType := range eventMap {
+		eventDispatcher.OnCustomizedEvent(eventType, receiveLongConnectionEvent)
+	}
+
+	opts := []larkws.ClientOption{
+		larkws.WithEventHandler(eventDispatcher),
+		larkws.WithAutoReconnect(true),
+		larkws.WithLogger(longConnectionLogger{}),
+	}
+	if c.Dispatcher.Domain != "" {
+		opts = append(opts, larkws.WithDomain(c.Dispatcher.Domain))
+	}
+	cli := larkws.NewClient(c.Feishu.AppId, c.Feishu.AppSecret, opts...)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopLongConnection = cancel
+	go func() {
+		if err := cli.Start(ctx); err != nil {
+			logrus.Error("Feishu long connection stopped: ", err)
+		}
+	}()
+	logrus.WithField("event types", len(eventMap)).Info("Receiving feishu events over long connection")
+}
+
+// receiveLongConnectionEvent puts an event from the long connection into the same dispatch path as http callbacks.
+// Returning an error makes feishu deliver the event again later
+func receiveLongConnectionEvent(ctx context.Context, event *larkevent.EventReq) error {
+	// 长连接推送的事件一般是明文，只有带encrypt字段时才解密
+	requestStr := string(event.Body)
+	var encrypted struct {
+		Encrypt string `json:"encrypt"`
+	}
+	if json.Unmarshal(event.Body, &encrypted) == nil && encrypted.Encrypt != "" {
+		var err error
+		if requestStr, err = decryptBody(event.Body); err != nil {
+			metrics.DispatcherRequests.WithLabelValues(outcomeBadRequest).Inc()
+			return err
+		}
+	}
+
+	var req FeishuEventRequest
+	if err := deserializeRequest(requestStr, &req); err != nil {
+		metrics.DispatcherRequests.WithLabelValues(outcomeBadRequest).Inc()
+		logrus.Warn("Reject feishu event from long connection: ", err)
+		return err
+	}
+	logrus.Debug("Feishu Robot received an event from long connection: ", req)
+
+	outcome := dispatch(req)
+	metrics.DispatcherRequests.WithLabelValues(outcome).Inc()
+	switch outcome {
+	case outcomeQueueFull:
+		return errors.New("event queue is full")
+	case outcomeStopping:
+		return errors.New("robot is shutting down")
+	}
+	return nil
+}
+
+// longConnectionLogger forwards the logs of the long connection client to logrus
+type longConnectionLogger struct{}
+
+var _ larkcore.Logger = longConnectionLogger{}
+
+func (longConnectionLogger) Debug(_ context.Context, args ...interface{}) {
+	logrus.WithField("transport", "websocket").Debug(args...)
+}
+
+func (longConnectionLogger) Info(_ context.Context, args ...interface{}) {
+	logrus.WithField("transport", "websocket").Info(args...)
+}
+
+func (longConnectionLogger) Warn(_ context.Context, args ...interface{}) {
+	logrus.WithField("transport", "websocket").Warn(args...)
+}
+
+func (longConnectionLogger) Error(_ context.Context, args ...interface{}) {
+	logrus.WithField("transport", "websocket").Error(args...)
+}
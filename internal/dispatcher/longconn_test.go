@@ -0,0 +1,276 @@
+package dispatcher
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	larkevent "github.com/larksuite/oapi-sdk-go/v3/event"
+	larkws "github.com/larksuite/oapi-sdk-go/v3/ws"
+)
+
+const testEventType = "test.event_v1"
+
+// uniqueEventId makes event ids differ between runs with -count, since handled events are remembered in the process
+func uniqueEventId(name string) string {
+	return name + "-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+}
+
+// workersOnce 工作池使用全局的队列，整个测试进程只启动一次
+var workersOnce sync.Once
+
+func startWorkers() {
+	workersOnce.Do(StartWorkers)
+}
+
+// encrypt is the reverse of decrypt, as feishu encrypts event bodies
+func encrypt(t *testing.T, plain string, key string) string {
+	t.Helper()
+	keyBs := sha256.Sum256([]byte(key))
+	block, err := aes.NewCipher(keyBs[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	padding := aes.BlockSize - len(plain)%aes.BlockSize
+	data := append([]byte(plain), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	buf := make([]byte, aes.BlockSize+len(data))
+	iv := buf[:aes.BlockSize]
+	if _, err := rand.Read(iv); err != nil {
+		t.Fatal(err)
+	}
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(buf[aes.BlockSize:], data)
+	return base64.StdEncoding.EncodeToString(buf)
+}
+
+// testEvent returns a v2 event request of testEventType
+func testEvent(eventId string) string {
+	return `{"schema":"2.0","header":{"event_id":"` + eventId + `","event_type":"` + testEventType + `","token":"` +
+		testVerificationToken + `","create_time":"` + strconv.FormatInt(time.Now().UnixMilli(), 10) + `"},"event":{"value":"` + eventId + `"}}`
+}
+
+// fakeFeishu serves the long connection endpoint and pushes the events written to push over the WebSocket.
+// The status code the client answers each event with is sent to acks
+type fakeFeishu struct {
+	server *httptest.Server
+	push   chan string
+	acks   chan int
+}
+
+func newFakeFeishu(t *testing.T) *fakeFeishu {
+	f := &fakeFeishu{push: make(chan string), acks: make(chan int)}
+	mux := http.NewServeMux()
+	mux.HandleFunc(larkws.GenEndpointUri, func(w http.ResponseWriter, r *http.Request) {
+		url := "ws" + strings.TrimPrefix(f.server.URL, "http") + "/ws?device_id=test&service_id=1"
+		json.NewEncoder(w).Encode(larkws.EndpointResp{Data: &larkws.Endpoint{Url: url}})
+	})
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		go f.readAcks(conn)
+		for i := 0; ; i++ {
+			body, ok := <-f.push
+			if !ok {
+				return
+			}
+			headers := larkws.Headers{}
+			headers.Add(larkws.HeaderType, string(larkws.MessageTypeEvent))
+			headers.Add(larkws.HeaderMessageID, strconv.Itoa(i))
+			headers.Add(larkws.HeaderSum, "1")
+			headers.Add(larkws.HeaderSeq, "0")
+			frame := larkws.Frame{Method: int32(larkws.FrameTypeData), Service: 1, Headers: headers, Payload: []byte(body)}
+			data, _ := frame.Marshal()
+			if err := conn.WriteMessage(websocket.BinaryMessage, data); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	})
+	f.server = httptest.NewServer(mux)
+	t.Cleanup(func() {
+		close(f.push)
+		f.server.Close()
+	})
+	return f
+}
+
+// readAcks forwards the status codes of the client's responses to data frames, skipping pings
+func (f *fakeFeishu) readAcks(conn *websocket.Conn) {
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var frame larkws.Frame
+		if frame.Unmarshal(data) != nil || frame.Method != int32(larkws.FrameTypeData) {
+			continue
+		}
+		var resp larkws.Response
+		json.Unmarshal(frame.Payload, &resp)
+		f.acks <- resp.StatusCode
+	}
+}
+
+// pushEvent delivers the event over the long connection and returns the client's status code
+func (f *fakeFeishu) pushEvent(t *testing.T, body string) int {
+	t.Helper()
+	select {
+	case f.push <- body:
+	case <-time.After(5 * time.Second):
+		t.Fatal("long connection client did not connect")
+	}
+	select {
+	case code := <-f.acks:
+		return code
+	case <-time.After(5 * time.Second):
+		t.Fatal("long connection client did not answer the event")
+	}
+	return 0
+}
+
+// postEvent delivers the event as a signed and encrypted http callback
+func postEvent(t *testing.T, server *httptest.Server, eventId string) *http.Response {
+	t.Helper()
+	body := `{"encrypt":"` + encrypt(t, testEvent(eventId), testEncryptKey) + `"}`
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req, _ := http.NewRequest(http.MethodPost, server.URL+"/feiShu/Event", strings.NewReader(body))
+	req.Header.Set("X-Lark-Request-Timestamp", timestamp)
+	req.Header.Set("X-Lark-Request-Nonce", "nonce-"+eventId)
+	req.Header.Set("X-Lark-Signature", calculateSignature(timestamp, "nonce-"+eventId, testEncryptKey, body))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+// TestTransports sends events over both transports and checks that they share deduplication and handlers,
+// and that both reject events once intake has stopped
+func TestTransports(t *testing.T) {
+	feishu := newFakeFeishu(t)
+	useConfig(t, "dispatcher:\n  domain: "+feishu.server.URL+"\n")
+	gin.SetMode(gin.TestMode)
+
+	handled := make(chan string, 10)
+	RegisterHandler(HandlerFunc(func(ctx context.Context, event Event) error {
+		handled <- event.Id
+		return nil
+	}), testEventType)
+	t.Cleanup(func() { delete(eventMap, testEventType) })
+	startWorkers()
+	StartLongConnection()
+
+	r := gin.New()
+	r.POST("/feiShu/Event", Dispatcher)
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	expectHandled := func(eventId string) {
+		t.Helper()
+		select {
+		case id := <-handled:
+			if id != eventId {
+				t.Fatalf("handled event %s, want %s", id, eventId)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("event %s was not handled", eventId)
+		}
+	}
+	expectNotHandled := func() {
+		t.Helper()
+		select {
+		case id := <-handled:
+			t.Fatalf("event %s was handled again", id)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	evtHTTP, evtWS := uniqueEventId("evt-http"), uniqueEventId("evt-ws")
+	if resp := postEvent(t, server, evtHTTP); resp.StatusCode != http.StatusOK {
+		t.Fatalf("http callback status = %d, want 200", resp.StatusCode)
+	}
+	expectHandled(evtHTTP)
+
+	if code := feishu.pushEvent(t, testEvent(evtWS)); code != http.StatusOK {
+		t.Fatalf("long connection status = %d, want 200", code)
+	}
+	expectHandled(evtWS)
+
+	// 同一事件从另一种方式再次送达时视为重复，不再处理，也不让飞书重试
+	if code := feishu.pushEvent(t, testEvent(evtHTTP)); code != http.StatusOK {
+		t.Fatalf("long connection status of duplicate = %d, want 200", code)
+	}
+	if resp := postEvent(t, server, evtWS); resp.StatusCode != http.StatusOK {
+		t.Fatalf("http callback status of duplicate = %d, want 200", resp.StatusCode)
+	}
+	expectNotHandled()
+
+	StopIntake()
+	t.Cleanup(func() {
+		intake.Lock()
+		intake.closed = false
+		intake.Unlock()
+	})
+	if code := feishu.pushEvent(t, testEvent(uniqueEventId("evt-late-ws"))); code != http.StatusInternalServerError {
+		t.Fatalf("long connection status after StopIntake = %d, want 500", code)
+	}
+	if resp := postEvent(t, server, uniqueEventId("evt-late-http")); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("http callback status after StopIntake = %d, want 503", resp.StatusCode)
+	}
+	expectNotHandled()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := Wait(ctx); err != nil {
+		t.Fatalf("Wait() = %v", err)
+	}
+}
+
+// TestReceiveEncryptedLongConnectionEvent checks that an encrypted event from the long connection
+// is decrypted like an http callback
+func TestReceiveEncryptedLongConnectionEvent(t *testing.T) {
+	useConfig(t, "")
+	handled := make(chan string, 1)
+	RegisterHandler(HandlerFunc(func(ctx context.Context, event Event) error {
+		handled <- event.Id
+		return nil
+	}), testEventType)
+	t.Cleanup(func() { delete(eventMap, testEventType) })
+	startWorkers()
+
+	eventId := uniqueEventId("evt-encrypted")
+	body := `{"encrypt":"` + encrypt(t, testEvent(eventId), testEncryptKey) + `"}`
+	if err := receiveLongConnectionEvent(context.Background(), &larkevent.EventReq{Body: []byte(body)}); err != nil {
+		t.Fatalf("receiveLongConnectionEvent() = %v", err)
+	}
+	select {
+	case id := <-handled:
+		if id != eventId {
+			t.Fatalf("handled event %s, want %s", id, eventId)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("encrypted event was not handled")
+	}
+
+	if err := receiveLongConnectionEvent(context.Background(), &larkevent.EventReq{Body: []byte(`{"encrypt":"garbage"}`)}); err == nil {
+		t.Fatal("receiveLongConnectionEvent() accepted an event that cannot be decrypted")
+	}
+}
@@ -1,34 +1,72 @@
 package dispatcher
 
-import "encoding/json"
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
 
+// CallbackType is the legacy handler signature, adapted to Handler by RegisterListener
 type CallbackType func(map[string]any)
 
+// Event 分发给Handler的飞书事件
+type Event struct {
+	Id         string
+	Type       string
+	CreateTime time.Time
+	TenantKey  string
+	// 请求中event字段的原始JSON
+	Raw json.RawMessage
+}
+
+// Handler handles one type of Feishu event.
+// ctx is cancelled when the handler timeout configured by dispatcher.handlerTimeout expires
+type Handler interface {
+	Handle(ctx context.Context, event Event) error
+}
+
+// HandlerFunc adapts a function to Handler
+type HandlerFunc func(ctx context.Context, event Event) error
+
+func (f HandlerFunc) Handle(ctx context.Context, event Event) error {
+	return f(ctx, event)
+}
+
 type FeishuEventRequestRaw struct {
 	Header struct {
-		EventType string `json:"event_type"`
-		Token     string `json:"token"`
-		EventId   string `json:"event_id"`
+		EventType  string `json:"event_type"`
+		Token      string `json:"token"`
+		EventId    string `json:"event_id"`
+		CreateTime string `json:"create_time"`
+		TenantKey  string `json:"tenant_key"`
 	} `json:"header"`
-	Schema    string         `json:"schema"`
-	Uuid      string         `json:"uuid"`
-	Type      string         `json:"type"`
-	Token     string         `json:"token"`
-	Event     map[string]any `json:"event"`
-	Challenge string         `json:"challenge"`
+	Schema    string          `json:"schema"`
+	Uuid      string          `json:"uuid"`
+	Ts        string          `json:"ts"`
+	Type      string          `json:"type"`
+	Token     string          `json:"token"`
+	Event     json.RawMessage `json:"event"`
+	Challenge string          `json:"challenge"`
 }
 
 type FeishuEventRequest struct {
-	EventId   string
-	EventType string
-	Token     string
-	Event     map[string]any
-	Challenge string
+	EventId    string
+	EventType  string
+	Token      string
+	CreateTime time.Time
+	TenantKey  string
+	Event      json.RawMessage
+	Challenge  string
 }
 
-func deserializeRequest(dataStr string, request *FeishuEventRequest) {
+func deserializeRequest(dataStr string, request *FeishuEventRequest) error {
 	var data FeishuEventRequestRaw
-	json.Unmarshal([]byte(dataStr), &data)
+	if err := json.Unmarshal([]byte(dataStr), &data); err != nil {
+		return fmt.Errorf("malformed event request: %w", err)
+	}
 
 	request.Challenge = data.Challenge
 	request.Event = data.Event
@@ -38,10 +76,50 @@ func deserializeRequest(dataStr string, request *FeishuEventRequest) {
 		request.EventId = data.Header.EventId
 		request.EventType = data.Header.EventType
 		request.Token = data.Header.Token
+		request.TenantKey = data.Header.TenantKey
+		// create_time is in milliseconds
+		if ms, err := strconv.ParseInt(data.Header.CreateTime, 10, 64); err == nil {
+			request.CreateTime = time.UnixMilli(ms)
+		}
 	} else {
 		// v1
 		request.EventId = data.Uuid
 		request.EventType = data.Type
 		request.Token = data.Token
+		// ts is in seconds, e.g. "1502199207.7171419"
+		if sec, err := strconv.ParseFloat(data.Ts, 64); err == nil {
+			request.CreateTime = time.UnixMilli(int64(sec * 1000))
+		}
+		var event struct {
+			TenantKey string `json:"tenant_key"`
+		}
+		json.Unmarshal(data.Event, &event)
+		request.TenantKey = event.TenantKey
+	}
+
+	if request.Challenge == "" && (request.EventId == "" || request.EventType == "") {
+		return errors.New("event request without event id or event type")
+	}
+	return nil
+}
+
+// String is used when the request is logged, with the verification token hidden
+func (r FeishuEventRequest) String() string {
+	token := ""
+	if r.Token != "" {
+		token = "***"
+	}
+	return fmt.Sprintf("{EventId:%s EventType:%s Token:%s CreateTime:%s TenantKey:%s Event:%s}",
+		r.EventId, r.EventType, token, r.CreateTime.Format(time.RFC3339), r.TenantKey, r.Event)
+}
+
+// envelope builds the Event passed to handlers
+func (r FeishuEventRequest) envelope() Event {
+	return Event{
+		Id:         r.EventId,
+		Type:       r.EventType,
+		CreateTime: r.CreateTime,
+		TenantKey:  r.TenantKey,
+		Raw:        r.Event,
 	}
 }
@@ -0,0 +1,119 @@
+package dispatcher
+
+import (
+	"context"
+	"runtime/debug"
+	"time"
+	"xlab-feishu-robot/internal/config"
+	"xlab-feishu-robot/internal/log"
+	"xlab-feishu-robot/internal/metrics"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 未配置时的默认值
+const (
+	defaultWorkers        = 4
+	defaultQueueSize      = 100
+	defaultHandlerTimeout = 5 * time.Minute
+)
+
+type task struct {
+	event   Event
+	handler Handler
+}
+
+var queue chan task
+
+var handlerTimeout time.Duration
+
+// StartWorkers starts the workers which run event handlers, with the pool size, queue length
+// and handler timeout from config
+func StartWorkers() {
+	c := config.C()
+	workers := c.Dispatcher.Workers
+	if workers <= 0 {
+		workers = defaultWorkers
+	}
+	queueSize := c.Dispatcher.QueueSize
+	if queueSize <= 0 {
+		queueSize = defaultQueueSize
+	}
+	handlerTimeout = c.Dispatcher.HandlerTimeout
+	if handlerTimeout <= 0 {
+		handlerTimeout = defaultHandlerTimeout
+	}
+
+	queue = make(chan task, queueSize)
+	metrics.DispatcherQueueCapacity.Set(float64(queueSize))
+	for i := 0; i < workers; i++ {
+		go worker()
+	}
+	logrus.WithFields(logrus.Fields{
+		"workers":         workers,
+		"queue size":      queueSize,
+		"handler timeout": handlerTimeout,
+	}).Info("Event handler workers started")
+}
+
+// enqueue puts the task into the queue without blocking, returns false if the queue is full
+func enqueue(t task) bool {
+	handlers.Add(1)
+	select {
+	case queue <- t:
+		metrics.DispatcherQueueDepth.Set(float64(len(queue)))
+		return true
+	default:
+		handlers.Done()
+		metrics.DispatcherRejected.WithLabelValues(t.event.Type).Inc()
+		return false
+	}
+}
+
+func worker() {
+	for t := range queue {
+		metrics.DispatcherQueueDepth.Set(float64(len(queue)))
+		run(t)
+	}
+}
+
+// run runs the handler and waits for it at most handlerTimeout.
+// When the timeout expires the handler's context is cancelled; a handler ignoring it keeps running
+// in the background, but frees the worker
+func run(t task) {
+	// 处理函数用logrus.WithContext(ctx)写的日志都带上事件id
+	ctx, cancel := context.WithTimeout(log.WithCorrelation(context.Background(), t.event.Id), handlerTimeout)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		defer handlers.Done()
+		defer close(done)
+		defer func() {
+			if err := recover(); err != nil {
+				metrics.HandlerPanics.WithLabelValues(t.event.Type).Inc()
+				logrus.WithContext(ctx).WithFields(logrus.Fields{
+					"event id":   t.event.Id,
+					"event type": t.event.Type,
+					"panic":      err,
+					"stack":      string(debug.Stack()),
+				}).Error("Event handler panicked")
+			}
+		}()
+		if err := t.handler.Handle(ctx, t.event); err != nil {
+			metrics.HandlerErrors.WithLabelValues(t.event.Type).Inc()
+			logrus.WithContext(ctx).WithFields(logrus.Fields{
+				"event id":   t.event.Id,
+				"event type": t.event.Type,
+				"error":      err,
+			}).Error("Event handler failed")
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		metrics.HandlerTimeouts.WithLabelValues(t.event.Type).Inc()
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"event id": t.event.Id, "event type": t.event.Type, "timeout": handlerTimeout}).Warn("Event handler timed out")
+	}
+}
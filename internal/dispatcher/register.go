@@ -1,12 +1,42 @@
 package dispatcher
 
-import "github.com/sirupsen/logrus"
+import (
+	"context"
+	"encoding/json"
+	"fmt"
 
-func RegisterListener(f CallbackType, eventType string) {
-	// Register a handler for a specific Feishu event
+	"github.com/sirupsen/logrus"
+)
 
+// RegisterHandler registers a handler for a specific Feishu event
+func RegisterHandler(h Handler, eventType string) {
 	if _, isEventExist := eventMap[eventType]; isEventExist {
 		logrus.Warning("Double declaration of event listener: ", eventType)
 	}
-	eventMap[eventType] = f
+	eventMap[eventType] = h
+}
+
+func RegisterListener(f CallbackType, eventType string) {
+	// Register a handler with the legacy signature for a specific Feishu event
+
+	RegisterHandler(HandlerFunc(func(ctx context.Context, event Event) error {
+		eventMap := make(map[string]any)
+		if err := json.Unmarshal(event.Raw, &eventMap); err != nil {
+			return err
+		}
+		f(eventMap)
+		return nil
+	}), eventType)
+}
+
+// RegisterTyped registers a handler which receives the event payload decoded into T.
+// A payload that cannot be decoded is reported as a handler error
+func RegisterTyped[T any](f func(ctx context.Context, event Event, payload *T) error, eventType string) {
+	RegisterHandler(HandlerFunc(func(ctx context.Context, event Event) error {
+		payload := new(T)
+		if err := json.Unmarshal(event.Raw, payload); err != nil {
+			return fmt.Errorf("decode %s event %s: %w", event.Type, event.Id, err)
+		}
+		return f(ctx, event, payload)
+	}), eventType)
 }
@@ -1,6 +1,19 @@
 package dispatcher
 
+import "sync"
+
 // set of event ids
 var eventIdList = make(map[string]bool)
 
-var eventMap = make(map[string]CallbackType)
+var eventIdMu sync.Mutex
+
+var eventMap = make(map[string]Handler)
+
+// running event handlers, waited for on shutdown
+var handlers sync.WaitGroup
+
+// intake 停机时关闭，之后到达的事件被拒绝，等待handlers时不会再有新的handler加入
+var intake struct {
+	sync.RWMutex
+	closed bool
+}
@@ -0,0 +1,109 @@
+package dispatcher
+
+import (
+	"crypto/subtle"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+	"xlab-feishu-robot/internal/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultRequestWindow 未配置dispatcher.requestWindow时请求时间戳的有效范围
+const defaultRequestWindow = 5 * time.Minute
+
+var (
+	errBadToken         = errors.New("verification token mismatch")
+	errMissingHeaders   = errors.New("missing signature headers")
+	errBadTimestamp     = errors.New("invalid request timestamp")
+	errExpiredTimestamp = errors.New("request timestamp outside the acceptance window")
+	errReplayedNonce    = errors.New("request nonce already used")
+	errBadSignature     = errors.New("signature mismatch")
+)
+
+// validationReasons 校验失败的原因，作为metrics的标签
+var validationReasons = map[error]string{
+	errBadToken:         "bad_token",
+	errMissingHeaders:   "missing_headers",
+	errBadTimestamp:     "bad_timestamp",
+	errExpiredTimestamp: "expired_timestamp",
+	errReplayedNonce:    "replayed_nonce",
+	errBadSignature:     "bad_signature",
+}
+
+// nonceCache 在请求有效期内记住已使用的nonce，拒绝重放的请求
+type nonceCache struct {
+	mu     sync.Mutex
+	expiry map[string]time.Time
+}
+
+var nonces = nonceCache{expiry: make(map[string]time.Time)}
+
+// use records the nonce until expireAt, and reports false if it was already used
+func (n *nonceCache) use(nonce string, now time.Time, expireAt time.Time) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for k, t := range n.expiry {
+		if now.After(t) {
+			delete(n.expiry, k)
+		}
+	}
+	if _, used := n.expiry[nonce]; used {
+		return false
+	}
+	n.expiry[nonce] = expireAt
+	return true
+}
+
+func requestWindow() time.Duration {
+	if window := config.C().Dispatcher.RequestWindow; window > 0 {
+		return window
+	}
+	return defaultRequestWindow
+}
+
+// validateRequest checks the token, and when an encrypt key is configured also the
+// timestamp, nonce and signature in the request headers
+func validateRequest(c *gin.Context, token string, rawBodyStr string) error {
+	conf := config.C()
+	if subtle.ConstantTimeCompare([]byte(token), []byte(conf.Feishu.VerificationToken)) != 1 {
+		return errBadToken
+	}
+
+	// feishu only signs requests when an encrypt key is configured
+	encryptKey := conf.Feishu.EncryptKey
+	if encryptKey == "" {
+		return nil
+	}
+
+	timestamp := c.Request.Header.Get("X-Lark-Request-Timestamp")
+	nonce := c.Request.Header.Get("X-Lark-Request-Nonce")
+	signature := c.Request.Header.Get("X-Lark-Signature")
+	if timestamp == "" || nonce == "" || signature == "" {
+		return errMissingHeaders
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errBadTimestamp
+	}
+	now := time.Now()
+	requestTime := time.Unix(seconds, 0)
+	window := requestWindow()
+	if requestTime.Before(now.Add(-window)) || requestTime.After(now.Add(window)) {
+		return errExpiredTimestamp
+	}
+
+	expected := calculateSignature(timestamp, nonce, encryptKey, rawBodyStr)
+	if subtle.ConstantTimeCompare([]byte(signature), []byte(expected)) != 1 {
+		return errBadSignature
+	}
+
+	// only remember nonces of correctly signed requests, so forged requests cannot fill the cache
+	if !nonces.use(nonce, now, requestTime.Add(window)) {
+		return errReplayedNonce
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package dispatcher
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"xlab-feishu-robot/internal/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	testVerificationToken = "test-verification-token"
+	testEncryptKey        = "test-encrypt-key"
+)
+
+// useConfig loads a valid config with the test token and encrypt key, plus the extra yaml lines
+func useConfig(t *testing.T, extra string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	yaml := `
+feishu:
+  appId: cli_test
+  appSecret: test-app-secret
+  verificationToken: ` + testVerificationToken + `
+  encryptKey: ` + testEncryptKey + `
+server:
+  port: 8080
+Info:
+  GroupID: oc_test
+  NodeToken: test-node-token
+  PersonInChargeID: ou_test
+` + extra
+	if err := os.WriteFile(path, []byte(yaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config.ReadConfig(path)
+}
+
+// signedRequest builds a callback request signed with the test encrypt key
+func signedRequest(body string, timestamp string, nonce string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/feiShu/Event", strings.NewReader(body))
+	req.Header.Set("X-Lark-Request-Timestamp", timestamp)
+	req.Header.Set("X-Lark-Request-Nonce", nonce)
+	req.Header.Set("X-Lark-Signature", calculateSignature(timestamp, nonce, testEncryptKey, body))
+	return req
+}
+
+func TestValidateRequest(t *testing.T) {
+	useConfig(t, "")
+	gin.SetMode(gin.TestMode)
+
+	const body = `{"encrypt":"..."}`
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	expired := strconv.FormatInt(time.Now().Add(-2*requestWindow()).Unix(), 10)
+	// nonce在进程内只能使用一次，用-count多次运行时每次使用不同的nonce
+	run := strconv.FormatInt(time.Now().UnixNano(), 36)
+	nonce := func(name string) string { return "nonce-" + name + "-" + run }
+
+	tests := []struct {
+		name  string
+		token string
+		req   func() *http.Request
+		// 校验前先用掉请求的nonce，模拟重放
+		usedNonce string
+		want      error
+	}{
+		{
+			name:  "bad token",
+			token: "wrong-token",
+			req:   func() *http.Request { return signedRequest(body, now, nonce("bad-token")) },
+			want:  errBadToken,
+		},
+		{
+			name:  "missing timestamp",
+			token: testVerificationToken,
+			req: func() *http.Request {
+				req := signedRequest(body, now, nonce("missing-timestamp"))
+				req.Header.Del("X-Lark-Request-Timestamp")
+				return req
+			},
+			want: errMissingHeaders,
+		},
+		{
+			name:  "missing nonce",
+			token: testVerificationToken,
+			req: func() *http.Request {
+				req := signedRequest(body, now, nonce("missing-nonce"))
+				req.Header.Del("X-Lark-Request-Nonce")
+				return req
+			},
+			want: errMissingHeaders,
+		},
+		{
+			name:  "missing signature",
+			token: testVerificationToken,
+			req: func() *http.Request {
+				req := signedRequest(body, now, nonce("missing-signature"))
+				req.Header.Del("X-Lark-Signature")
+				return req
+			},
+			want: errMissingHeaders,
+		},
+		{
+			name:  "malformed timestamp",
+			token: testVerificationToken,
+			req:   func() *http.Request { return signedRequest(body, "yesterday", nonce("malformed-timestamp")) },
+			want:  errBadTimestamp,
+		},
+		{
+			name:  "timestamp outside window",
+			token: testVerificationToken,
+			req:   func() *http.Request { return signedRequest(body, expired, nonce("expired")) },
+			want:  errExpiredTimestamp,
+		},
+		{
+			name:      "replayed nonce",
+			token:     testVerificationToken,
+			req:       func() *http.Request { return signedRequest(body, now, nonce("replayed")) },
+			usedNonce: nonce("replayed"),
+			want:      errReplayedNonce,
+		},
+		{
+			name:  "bad signature",
+			token: testVerificationToken,
+			req: func() *http.Request {
+				req := signedRequest(body, now, nonce("bad-signature"))
+				req.Header.Set("X-Lark-Signature", calculateSignature(now, nonce("bad-signature"), "other-key", body))
+				return req
+			},
+			want: errBadSignature,
+		},
+		{
+			name:  "valid",
+			token: testVerificationToken,
+			req:   func() *http.Request { return signedRequest(body, now, nonce("valid")) },
+			want:  nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.usedNonce != "" {
+				nonces.use(tt.usedNonce, time.Now(), time.Now().Add(requestWindow()))
+			}
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = tt.req()
+			if err := validateRequest(c, tt.token, body); err != tt.want {
+				t.Errorf("validateRequest() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNonceCache(t *testing.T) {
+	n := nonceCache{expiry: make(map[string]time.Time)}
+	now := time.Now()
+	if !n.use("a", now, now.Add(time.Minute)) {
+		t.Fatal("first use of a nonce was rejected")
+	}
+	if n.use("a", now, now.Add(time.Minute)) {
+		t.Fatal("second use of a nonce was accepted")
+	}
+	// 过期后nonce被清理，可以再次使用
+	later := now.Add(2 * time.Minute)
+	if !n.use("a", later, later.Add(time.Minute)) {
+		t.Fatal("use of an expired nonce was rejected")
+	}
+}
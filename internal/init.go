@@ -1,9 +1,11 @@
 package internal
 
 import (
+	"xlab-feishu-robot/internal/controller"
 	"xlab-feishu-robot/internal/dispatcher"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func Init(r *gin.Engine) {
@@ -11,11 +13,31 @@ func Init(r *gin.Engine) {
 }
 
 func Register(r *gin.Engine) {
-	r.GET("/api/ping", func(c *gin.Context) {
-		c.String(200, "pong")
-	})
+	r.GET("/api/ping", Ping)
+	r.GET("/healthz", controller.Healthz)
+	r.GET("/readyz", controller.Readyz)
+
+	// prometheus metrics
+	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
+
+	// 导出内容包含成员的open_id，与管理接口使用同一个token
+	r.GET("/api/export/:year/:month", controller.AdminAuth, controller.Export)
+
+	admin := r.Group("/api/admin", controller.AdminAuth)
+	admin.GET("/jobs", controller.ListJobs)
+	admin.POST("/jobs/:name/run", controller.RunJob)
+	admin.GET("/outbox", controller.GetOutbox)
+	admin.GET("/config", controller.GetConfig)
 
 	// DO NOT CHANGE LINES BELOW
 	// register dispatcher
 	r.POST("/feiShu/Event", dispatcher.Dispatcher)
 }
+
+// @Summary ping
+// @Tags health
+// @Success 200 {string} pong
+// @Router /api/ping [get]
+func Ping(c *gin.Context) {
+	c.String(200, "pong")
+}
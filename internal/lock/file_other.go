@@ -0,0 +1,23 @@
+//go:build !unix
+
+package lock
+
+import (
+	"context"
+	"errors"
+)
+
+// FileLock 文件锁只在unix系统上可用
+type FileLock struct{}
+
+func NewFileLock(path string) *FileLock {
+	return &FileLock{}
+}
+
+func (l *FileLock) TryLock(ctx context.Context) (bool, error) {
+	return false, errors.New("file lock is only supported on unix")
+}
+
+func (l *FileLock) Unlock(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,59 @@
+//go:build unix
+
+package lock
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+// FileLock 基于flock的文件锁，进程退出时由操作系统自动释放
+type FileLock struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+func NewFileLock(path string) *FileLock {
+	return &FileLock{path: path}
+}
+
+func (l *FileLock) TryLock(ctx context.Context) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file != nil {
+		return true, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(l.path), os.ModePerm); err != nil {
+		return false, err
+	}
+	file, err := os.OpenFile(l.path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return false, err
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if err == syscall.EWOULDBLOCK {
+			return false, nil
+		}
+		return false, err
+	}
+	l.file = file
+	return true, nil
+}
+
+func (l *FileLock) Unlock(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := syscall.Flock(int(l.file.Fd()), syscall.LOCK_UN)
+	l.file.Close()
+	l.file = nil
+	return err
+}
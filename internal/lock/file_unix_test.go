@@ -0,0 +1,36 @@
+//go:build unix
+
+package lock
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileLock(t *testing.T) {
+	ctx := context.Background()
+	// flock的锁属于打开的文件，同一进程内两次打开同一文件也会互斥
+	path := filepath.Join(t.TempDir(), "data", "leader.lock")
+	a, b := NewFileLock(path), NewFileLock(path)
+
+	if held, err := a.TryLock(ctx); !held || err != nil {
+		t.Fatalf("a.TryLock() = %v, %v, want true, nil", held, err)
+	}
+	if held, err := a.TryLock(ctx); !held || err != nil {
+		t.Fatalf("a.TryLock() again = %v, %v, want true, nil", held, err)
+	}
+	if held, err := b.TryLock(ctx); held || err != nil {
+		t.Fatalf("b.TryLock() while a holds the lock = %v, %v, want false, nil", held, err)
+	}
+
+	if err := a.Unlock(ctx); err != nil {
+		t.Fatalf("a.Unlock() = %v", err)
+	}
+	if held, err := b.TryLock(ctx); !held || err != nil {
+		t.Fatalf("b.TryLock() after a released = %v, %v, want true, nil", held, err)
+	}
+	if err := b.Unlock(ctx); err != nil {
+		t.Fatalf("b.Unlock() = %v", err)
+	}
+}
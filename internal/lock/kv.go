@@ -0,0 +1,40 @@
+package lock
+
+import (
+	"context"
+	"time"
+)
+
+// KV 分布式锁所需的KV操作，可以用Redis（SET NX PX + Lua脚本）或etcd（lease + txn）实现
+type KV interface {
+	// SetNX sets key to value with ttl if key does not exist, and reports whether it was set
+	SetNX(ctx context.Context, key string, value string, ttl time.Duration) (bool, error)
+	// Refresh resets the ttl of key if it holds value, and reports whether it did
+	Refresh(ctx context.Context, key string, value string, ttl time.Duration) (bool, error)
+	// CompareAndDelete deletes key if it holds value, and reports whether it did
+	CompareAndDelete(ctx context.Context, key string, value string) (bool, error)
+}
+
+// KVLock 基于KV的带租约的锁，持有者需要在ttl内续约
+type KVLock struct {
+	kv    KV
+	key   string
+	value string
+	ttl   time.Duration
+}
+
+func NewKVLock(kv KV, key string, ttl time.Duration) *KVLock {
+	return &KVLock{kv: kv, key: key, value: instanceID(), ttl: ttl}
+}
+
+func (l *KVLock) TryLock(ctx context.Context) (bool, error) {
+	if ok, err := l.kv.Refresh(ctx, l.key, l.value, l.ttl); ok || err != nil {
+		return ok, err
+	}
+	return l.kv.SetNX(ctx, l.key, l.value, l.ttl)
+}
+
+func (l *KVLock) Unlock(ctx context.Context) error {
+	_, err := l.kv.CompareAndDelete(ctx, l.key, l.value)
+	return err
+}
@@ -0,0 +1,118 @@
+package lock
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryKV 进程内的KV实现，代替Redis/etcd用于测试
+type memoryKV struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+}
+
+type memoryEntry struct {
+	value    string
+	expireAt time.Time
+}
+
+func newMemoryKV() *memoryKV {
+	return &memoryKV{entries: make(map[string]memoryEntry)}
+}
+
+// get returns the entry of key if it exists and has not expired, must be called with mu held
+func (m *memoryKV) get(key string) (memoryEntry, bool) {
+	entry, ok := m.entries[key]
+	if ok && time.Now().After(entry.expireAt) {
+		delete(m.entries, key)
+		return memoryEntry{}, false
+	}
+	return entry, ok
+}
+
+func (m *memoryKV) SetNX(ctx context.Context, key string, value string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.get(key); ok {
+		return false, nil
+	}
+	m.entries[key] = memoryEntry{value: value, expireAt: time.Now().Add(ttl)}
+	return true, nil
+}
+
+func (m *memoryKV) Refresh(ctx context.Context, key string, value string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if entry, ok := m.get(key); !ok || entry.value != value {
+		return false, nil
+	}
+	m.entries[key] = memoryEntry{value: value, expireAt: time.Now().Add(ttl)}
+	return true, nil
+}
+
+func (m *memoryKV) CompareAndDelete(ctx context.Context, key string, value string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if entry, ok := m.get(key); !ok || entry.value != value {
+		return false, nil
+	}
+	delete(m.entries, key)
+	return true, nil
+}
+
+func TestKVLock(t *testing.T) {
+	ctx := context.Background()
+	kv := newMemoryKV()
+	a := NewKVLock(kv, "leader", time.Minute)
+	b := NewKVLock(kv, "leader", time.Minute)
+
+	if held, err := a.TryLock(ctx); !held || err != nil {
+		t.Fatalf("a.TryLock() = %v, %v, want true, nil", held, err)
+	}
+	if held, err := a.TryLock(ctx); !held || err != nil {
+		t.Fatalf("a.TryLock() renew = %v, %v, want true, nil", held, err)
+	}
+	if held, err := b.TryLock(ctx); held || err != nil {
+		t.Fatalf("b.TryLock() while a holds the lock = %v, %v, want false, nil", held, err)
+	}
+
+	// 不持有锁的一方解锁不影响持有者
+	if err := b.Unlock(ctx); err != nil {
+		t.Fatalf("b.Unlock() = %v", err)
+	}
+	if held, _ := b.TryLock(ctx); held {
+		t.Fatal("b acquired the lock after unlocking a lock it did not hold")
+	}
+
+	if err := a.Unlock(ctx); err != nil {
+		t.Fatalf("a.Unlock() = %v", err)
+	}
+	if held, err := b.TryLock(ctx); !held || err != nil {
+		t.Fatalf("b.TryLock() after a released = %v, %v, want true, nil", held, err)
+	}
+	if held, _ := a.TryLock(ctx); held {
+		t.Fatal("a acquired the lock held by b")
+	}
+}
+
+func TestKVLockExpires(t *testing.T) {
+	ctx := context.Background()
+	kv := newMemoryKV()
+	a := NewKVLock(kv, "leader", 20*time.Millisecond)
+	b := NewKVLock(kv, "leader", 20*time.Millisecond)
+
+	if held, _ := a.TryLock(ctx); !held {
+		t.Fatal("a.TryLock() = false, want true")
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	// a没有续约，租约到期后b可以获得锁，a不能再续约
+	if held, _ := b.TryLock(ctx); !held {
+		t.Fatal("b.TryLock() after the lease expired = false, want true")
+	}
+	if held, _ := a.TryLock(ctx); held {
+		t.Fatal("a renewed a lease that had expired and was taken over")
+	}
+}
@@ -0,0 +1,59 @@
+package lock
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Locker 多个副本之间的互斥锁，只有持有锁的副本执行定时任务
+type Locker interface {
+	// TryLock acquires the lock, or renews it if it is already held by this process.
+	// It reports whether this process holds the lock afterwards
+	TryLock(ctx context.Context) (bool, error)
+	// Unlock releases the lock if it is held by this process
+	Unlock(ctx context.Context) error
+}
+
+// 锁的类型
+const (
+	// 不加锁，每个进程都执行定时任务
+	TypeNone = "none"
+	// 文件锁，用于同一台主机上的多个进程
+	TypeFile = "file"
+)
+
+// New creates a Locker of the given type. ttl is the lease of KV based locks (Redis/etcd),
+// the lock types available so far do not use it
+func New(lockType string, file string, ttl time.Duration) (Locker, error) {
+	switch lockType {
+	case "", TypeNone:
+		return noLock{}, nil
+	case TypeFile:
+		return NewFileLock(file), nil
+	default:
+		return nil, fmt.Errorf("unknown lock type: %s", lockType)
+	}
+}
+
+// noLock 总是认为自己持有锁
+type noLock struct{}
+
+func (noLock) TryLock(ctx context.Context) (bool, error) { return true, nil }
+
+func (noLock) Unlock(ctx context.Context) error { return nil }
+
+// processInstanceID 当前进程的实例id，进程内不变
+var processInstanceID = instanceID()
+
+// InstanceID returns the id of this process, which differs between replicas and between restarts
+func InstanceID() string {
+	return processInstanceID
+}
+
+// instanceID 标识当前进程，作为KV锁的值
+func instanceID() string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d-%d", hostname, os.Getpid(), time.Now().UnixNano())
+}
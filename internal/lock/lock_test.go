@@ -0,0 +1,43 @@
+package lock
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNew(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "leader.lock")
+	tests := []struct {
+		lockType string
+		wantErr  bool
+	}{
+		{"", false},
+		{TypeNone, false},
+		{TypeFile, false},
+		// 进程内的锁只用于测试，不能通过配置选择
+		{"memory", true},
+		{"redis", true},
+	}
+	for _, tt := range tests {
+		locker, err := New(tt.lockType, file, time.Minute)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("New(%q) error = %v, wantErr %v", tt.lockType, err, tt.wantErr)
+		}
+		if err == nil && locker == nil {
+			t.Errorf("New(%q) returned a nil Locker", tt.lockType)
+		}
+	}
+}
+
+func TestNoLock(t *testing.T) {
+	ctx := context.Background()
+	a, _ := New(TypeNone, "", time.Minute)
+	b, _ := New(TypeNone, "", time.Minute)
+	for _, l := range []Locker{a, b} {
+		if held, err := l.TryLock(ctx); !held || err != nil {
+			t.Errorf("TryLock() = %v, %v, want true, nil", held, err)
+		}
+	}
+}
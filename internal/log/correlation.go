@@ -0,0 +1,55 @@
+package log
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/sirupsen/logrus"
+)
+
+// CorrelationField 日志中关联id的字段名
+const CorrelationField = "correlation id"
+
+// 关联id（飞书事件id或任务执行id）随context传递，
+// 用logrus.WithContext(ctx)写的日志会由correlationHook带上关联id。
+// feishuapi不接受context，直接使用全局的logrus写日志，它自己打印的日志（如请求失败）无法带上关联id
+type correlationKey struct{}
+
+// WithCorrelation returns a copy of ctx carrying the correlation id
+func WithCorrelation(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationKey{}, id)
+}
+
+// Correlation returns the correlation id carried by ctx, or an empty string
+func Correlation(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(correlationKey{}).(string)
+	return id
+}
+
+// NewCorrelationId returns a random id for a job run
+func NewCorrelationId() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// correlationHook adds the correlation id of the entry's context to log entries
+type correlationHook struct{}
+
+func (correlationHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (correlationHook) Fire(entry *logrus.Entry) error {
+	if _, ok := entry.Data[CorrelationField]; ok {
+		return nil
+	}
+	if id := Correlation(entry.Context); id != "" {
+		entry.Data[CorrelationField] = id
+	}
+	return nil
+}
@@ -1,29 +1,78 @@
 package log
 
 import (
-	"fmt"
 	"io"
 	"os"
 	"path/filepath"
-	"time"
+	"xlab-feishu-robot/internal/config"
 
 	"github.com/sirupsen/logrus"
+	"gopkg.in/natefinch/lumberjack.v2"
 )
 
+// 未配置log时的默认值
+const (
+	defaultDir     = "./log"
+	defaultMaxSize = 100
+)
+
+// setFormatAndLevel applies log.format and log.level, which may change while running.
+// Unknown values are rejected by config.Validate, the fallbacks here only guard against misuse
+func setFormatAndLevel(c *config.Config) {
+	switch c.Log.Format {
+	case config.LogFormatJSON:
+		logrus.SetFormatter(&logrus.JSONFormatter{})
+	case config.LogFormatText, "":
+		logrus.SetFormatter(&logrus.TextFormatter{FullTimestamp: true})
+	default:
+		logrus.Warn("Unknown log format, using text: ", c.Log.Format)
+		logrus.SetFormatter(&logrus.TextFormatter{FullTimestamp: true})
+	}
+
+	level := logrus.InfoLevel
+	if c.Log.Level != "" {
+		var err error
+		if level, err = logrus.ParseLevel(c.Log.Level); err != nil {
+			logrus.Warn("Unknown log level, using info: ", c.Log.Level)
+			level = logrus.InfoLevel
+		}
+	}
+	logrus.SetLevel(level)
+}
+
+// SetupLogrus sets the format and level of logrus from config, and writes logs to stdout
+// and to dir/robot.log, which is rotated by size and cleaned up by age and count
 func SetupLogrus() {
-	logPath := "./log/log_" + time.Now().Format("20060102_150405.00000")
-	if err := os.MkdirAll(filepath.Dir(logPath), os.ModePerm); err != nil {
-		logrus.Error("Failed to create log directory: ", filepath.Dir(logPath))
-		logrus.SetOutput(io.MultiWriter(os.Stdout))
-		return
-	} else {
-		logFile, err := os.OpenFile(logPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
-		if err != nil {
-			fmt.Println("Failed to create log file: ", logPath)
-			logrus.SetOutput(io.MultiWriter(os.Stdout))
-		} else {
-			logrus.SetOutput(io.MultiWriter(os.Stdout, logFile))
+	c := config.C()
+	setFormatAndLevel(c)
+	config.OnChange(func(old *config.Config, new *config.Config) {
+		if old.Log.Format != new.Log.Format || old.Log.Level != new.Log.Level {
+			setFormatAndLevel(new)
 		}
+	})
+
+	logrus.AddHook(correlationHook{})
+	logrus.AddHook(redactHook{})
+
+	dir := c.Log.Dir
+	if dir == "" {
+		dir = defaultDir
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		logrus.WithField("dir", dir).Error("Failed to create log directory, logging to stdout only: ", err)
+		logrus.SetOutput(os.Stdout)
 		return
 	}
+	maxSize := c.Log.MaxSize
+	if maxSize <= 0 {
+		maxSize = defaultMaxSize
+	}
+	logrus.SetOutput(io.MultiWriter(os.Stdout, &lumberjack.Logger{
+		Filename:   filepath.Join(dir, "robot.log"),
+		MaxSize:    maxSize,
+		MaxAge:     c.Log.MaxAge,
+		MaxBackups: c.Log.MaxBackups,
+		LocalTime:  true,
+		Compress:   c.Log.Compress,
+	}))
 }
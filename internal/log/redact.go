@@ -0,0 +1,24 @@
+package log
+
+import (
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// tenantTokenMessage feishuapi获取tenant_access_token后以Info级别打印token，由redactHook隐藏
+const tenantTokenMessage = "got tenant_access_token: "
+
+// redactHook hides secrets which third-party clients write into log messages
+type redactHook struct{}
+
+func (redactHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (redactHook) Fire(entry *logrus.Entry) error {
+	if strings.HasPrefix(entry.Message, tenantTokenMessage) {
+		entry.Message = tenantTokenMessage + "***"
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "feishu_robot"
+
+// dispatcher
+var (
+	DispatcherRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "dispatcher",
+		Name:      "requests_total",
+		Help:      "Feishu event requests, by outcome.",
+	}, []string{"outcome"})
+	EventsReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "dispatcher",
+		Name:      "events_received_total",
+		Help:      "Feishu events received over any transport, by event type.",
+	}, []string{"event_type"})
+	EventsDuplicate = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "dispatcher",
+		Name:      "events_duplicate_total",
+		Help:      "Feishu events ignored because they were received before, by event type.",
+	}, []string{"event_type"})
+	ValidationFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "dispatcher",
+		Name:      "validation_failures_total",
+		Help:      "Feishu event requests that failed token or signature validation, by reason.",
+	}, []string{"reason"})
+	DispatcherQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "dispatcher",
+		Name:      "queue_depth",
+		Help:      "Number of events waiting for a worker.",
+	})
+	DispatcherQueueCapacity = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "dispatcher",
+		Name:      "queue_capacity",
+		Help:      "Maximum number of events waiting for a worker.",
+	})
+	DispatcherRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "dispatcher",
+		Name:      "rejected_total",
+		Help:      "Events rejected because the queue was full, by event type.",
+	}, []string{"event_type"})
+	HandlerErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "dispatcher",
+		Name:      "handler_errors_total",
+		Help:      "Event handlers that returned an error, by event type.",
+	}, []string{"event_type"})
+	HandlerPanics = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "dispatcher",
+		Name:      "handler_panics_total",
+		Help:      "Event handlers that panicked, by event type.",
+	}, []string{"event_type"})
+	HandlerTimeouts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "dispatcher",
+		Name:      "handler_timeouts_total",
+		Help:      "Event handlers that did not finish within the timeout, by event type.",
+	}, []string{"event_type"})
+)
+
+// outbound messages
+var (
+	MessagesSent = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "sender",
+		Name:      "messages_total",
+		Help:      "Attempts to send a message, by message type and result (sent, retry, rate_limited, given_up).",
+	}, []string{"msg_type", "result"})
+	SendQueueLength = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "sender",
+		Name:      "queue_length",
+		Help:      "Number of messages waiting to be sent.",
+	})
+)
+
+// scheduled jobs
+var (
+	JobRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "cron",
+		Name:      "job_runs_total",
+		Help:      "Job runs, by job, trigger and outcome.",
+	}, []string{"job", "trigger", "outcome"})
+	JobDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "cron",
+		Name:      "job_duration_seconds",
+		Help:      "Duration of job runs, by job.",
+		Buckets:   []float64{0.5, 1, 5, 15, 30, 60, 120, 300, 600},
+	}, []string{"job"})
+)
+
+// feishu open api
+var (
+	APIRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "feishu_api",
+		Name:      "requests_total",
+		Help:      "Requests to the feishu open api, by method, endpoint and result (ok, http_error, api_error, network_error).",
+	}, []string{"method", "endpoint", "result"})
+	APIDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "feishu_api",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of requests to the feishu open api, by method and endpoint.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "endpoint"})
+)
@@ -0,0 +1,79 @@
+package model
+
+// UserId 飞书用户的各种id
+type UserId struct {
+	Union_id string `json:"union_id"`
+	User_id  string `json:"user_id"`
+	Open_id  string `json:"open_id"`
+}
+
+// 事件类型
+const (
+	EventMessageReceive    = "im.message.receive_v1"
+	EventMessageRead       = "im.message.message_read_v1"
+	EventReactionCreated   = "im.message.reaction.created_v1"
+	EventReactionDeleted   = "im.message.reaction.deleted_v1"
+	EventChatMemberAdded   = "im.chat.member.user.added_v1"
+	EventChatMemberDeleted = "im.chat.member.user.deleted_v1"
+	EventBotAdded          = "im.chat.member.bot.added_v1"
+	EventBotDeleted        = "im.chat.member.bot.deleted_v1"
+	EventDriveFileEdit     = "drive.file.edit_v1"
+)
+
+// ChatMemberUserEvent 用户进群/出群事件
+// for more detailed information, see https://open.feishu.cn/document/uAjLw4CM/ukTMukTMukTM/reference/im-v1/chat-member-user/events/added
+type ChatMemberUserEvent struct {
+	Chat_id             string `json:"chat_id"`
+	Operator_id         UserId `json:"operator_id"`
+	External            bool   `json:"external"`
+	Operator_tenant_key string `json:"operator_tenant_key"`
+	Name                string `json:"name"`
+	Users               []struct {
+		Name       string `json:"name"`
+		Tenant_key string `json:"tenant_key"`
+		User_id    UserId `json:"user_id"`
+	} `json:"users"`
+}
+
+// ChatMemberBotEvent 机器人进群/被移出群事件
+// for more detailed information, see https://open.feishu.cn/document/uAjLw4CM/ukTMukTMukTM/reference/im-v1/chat-member-bot/events/added
+type ChatMemberBotEvent struct {
+	Chat_id             string `json:"chat_id"`
+	Operator_id         UserId `json:"operator_id"`
+	External            bool   `json:"external"`
+	Operator_tenant_key string `json:"operator_tenant_key"`
+	Name                string `json:"name"`
+}
+
+// MessageReadEvent 消息已读事件
+// for more detailed information, see https://open.feishu.cn/document/uAjLw4CM/ukTMukTMukTM/reference/im-v1/message/events/message_read
+type MessageReadEvent struct {
+	Reader struct {
+		Reader_id  UserId `json:"reader_id"`
+		Read_time  string `json:"read_time"`
+		Tenant_key string `json:"tenant_key"`
+	} `json:"reader"`
+	Message_id_list []string `json:"message_id_list"`
+}
+
+// MessageReactionEvent 新增/删除消息表情回复事件
+// for more detailed information, see https://open.feishu.cn/document/uAjLw4CM/ukTMukTMukTM/reference/im-v1/message-reaction/events/created
+type MessageReactionEvent struct {
+	Message_id    string `json:"message_id"`
+	Reaction_type struct {
+		Emoji_type string `json:"emoji_type"`
+	} `json:"reaction_type"`
+	Operator_type string `json:"operator_type"`
+	User_id       UserId `json:"user_id"`
+	App_id        string `json:"app_id"`
+	Action_time   string `json:"action_time"`
+}
+
+// DriveFileEditEvent 文件编辑事件，需要先订阅对应的云文档
+// for more detailed information, see https://open.feishu.cn/document/uAjLw4CM/ukTMukTMukTM/reference/drive-v1/file/events/edit
+type DriveFileEditEvent struct {
+	File_type          string   `json:"file_type"`
+	File_token         string   `json:"file_token"`
+	Operator_id_list   []UserId `json:"operator_id_list"`
+	Subscriber_id_list []UserId `json:"subscriber_id_list"`
+}
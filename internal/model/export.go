@@ -0,0 +1,59 @@
+package model
+
+// ExportSchemaVersion 导出数据的JSON格式版本，格式有不兼容的改动时递增
+const ExportSchemaVersion = "1"
+
+// MonthlyExport 某个月知识树表格的导出数据
+type MonthlyExport struct {
+	SchemaVersion string         `json:"schema_version" example:"1"`
+	Year          int            `json:"year" example:"2023"`
+	Month         int            `json:"month" example:"5"`
+	Records       []ExportRecord `json:"records"`
+}
+
+// ExportRecord 导出的一条记录
+type ExportRecord struct {
+	Maintainers  []ExportMaintainer `json:"maintainers"`
+	Introduction string             `json:"introduction"`
+	Links        []ExportLink       `json:"links"`
+	LikeCount    int                `json:"like_count"`
+	// 创建时间，毫秒时间戳
+	CreatedAt int64 `json:"created_at" example:"1682870400000"`
+}
+
+type ExportMaintainer struct {
+	Name   string `json:"name"`
+	OpenID string `json:"open_id"`
+}
+
+type ExportLink struct {
+	Text string `json:"text"`
+	URL  string `json:"url"`
+}
+
+// NewMonthlyExport 将解析后的Record转换为导出格式
+func NewMonthlyExport(year int, month int, records []Record) MonthlyExport {
+	result := MonthlyExport{
+		SchemaVersion: ExportSchemaVersion,
+		Year:          year,
+		Month:         month,
+		Records:       make([]ExportRecord, 0, len(records)),
+	}
+	for _, record := range records {
+		exportRecord := ExportRecord{
+			Maintainers:  make([]ExportMaintainer, 0, len(record.Maintainers)),
+			Introduction: record.OneLineIntroduction,
+			Links:        make([]ExportLink, 0, len(record.NodeLink)),
+			LikeCount:    record.LikeCount,
+			CreatedAt:    int64(record.TimeStamp),
+		}
+		for _, maintainer := range record.Maintainers {
+			exportRecord.Maintainers = append(exportRecord.Maintainers, ExportMaintainer{Name: maintainer.Name, OpenID: maintainer.ID})
+		}
+		for _, link := range record.NodeLink {
+			exportRecord.Links = append(exportRecord.Links, ExportLink{Text: link.Text, URL: link.URL})
+		}
+		result.Records = append(result.Records, exportRecord)
+	}
+	return result
+}
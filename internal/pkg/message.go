@@ -0,0 +1,57 @@
+package pkg
+
+import (
+	"sync"
+	"time"
+
+	"github.com/YasyaKarasu/feishuapi"
+	"github.com/sirupsen/logrus"
+)
+
+// outboxSize 内存中最多保留的消息条数
+const outboxSize = 200
+
+// OutboxMessage dry-run模式下被拦截的消息
+type OutboxMessage struct {
+	Time          time.Time                 `json:"time"`
+	ReceiveIdType feishuapi.MsgReceiverType `json:"receive_id_type" swaggertype:"string" example:"chat_id"`
+	ReceiveId     string                    `json:"receive_id"`
+	MsgType       feishuapi.MsgContentType  `json:"msg_type" swaggertype:"string" example:"text"`
+	Content       string                    `json:"content"`
+}
+
+var outbox struct {
+	sync.Mutex
+	messages []OutboxMessage
+}
+
+// captureMessage logs a message which is not sent in dry-run mode and keeps it in the outbox
+func captureMessage(receiveIdType feishuapi.MsgReceiverType, receiveId string, msgType feishuapi.MsgContentType, msg string) {
+	logrus.WithFields(logrus.Fields{
+		"receive id type": receiveIdType,
+		"receive id":      receiveId,
+		"message":         msg,
+	}).Info("Dry-run mode, message captured into outbox")
+
+	outbox.Lock()
+	defer outbox.Unlock()
+	outbox.messages = append(outbox.messages, OutboxMessage{
+		Time:          time.Now(),
+		ReceiveIdType: receiveIdType,
+		ReceiveId:     receiveId,
+		MsgType:       msgType,
+		Content:       msg,
+	})
+	if len(outbox.messages) > outboxSize {
+		outbox.messages = outbox.messages[len(outbox.messages)-outboxSize:]
+	}
+}
+
+// Outbox returns the messages captured in dry-run mode, oldest first
+func Outbox() []OutboxMessage {
+	outbox.Lock()
+	defer outbox.Unlock()
+	result := make([]OutboxMessage, len(outbox.messages))
+	copy(result, outbox.messages)
+	return result
+}
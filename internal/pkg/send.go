@@ -0,0 +1,185 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"xlab-feishu-robot/internal/config"
+
+	"github.com/YasyaKarasu/feishuapi"
+)
+
+// feishuapi 的 MessageSend 只返回是否成功，无法区分限流、临时错误和永久错误，
+// 因此发送队列自己调用发送消息接口，使用单独获取的 tenant_access_token
+
+// apiHost 飞书开放平台的地址，与 feishuapi 使用的相同
+var apiHost = "https://open.feishu.cn"
+
+// apiTimeout 与 feishuapi 的请求超时相同
+const apiTimeout = 15 * time.Second
+
+// 发送消息接口的路径
+const messagesPath = "/open-apis/im/v1/messages"
+
+// 飞书表示限流的错误码：应用频率限制、同一会话发送频率限制
+const (
+	codeAppRateLimited  = 99991400
+	codeChatRateLimited = 230020
+)
+
+// 飞书表示tenant_access_token无效或过期的错误码，重新获取token后重试
+const (
+	codeTokenInvalid = 99991663
+	codeTokenMissing = 99991661
+)
+
+// tokenRefreshMargin 在token过期前这么久重新获取
+const tokenRefreshMargin = 5 * time.Minute
+
+// apiClient 使用默认的Transport，安装feishuTransport后与feishuapi的请求一样记录metrics
+var apiClient = &http.Client{Timeout: apiTimeout}
+
+// senderRequest 标记发送队列自己发出的请求，feishuTransport据此区分两份token的刷新
+type senderRequest struct{}
+
+var tenantToken struct {
+	sync.Mutex
+	token    string
+	expireAt time.Time
+}
+
+// sendAttempt 一次发送的结果
+type sendAttempt struct {
+	messageId string
+	err       error
+	// 被限流，retryAfter为飞书建议的等待时间，未给出时为0
+	rateLimited bool
+	retryAfter  time.Duration
+	// 重试也不会成功的错误，如接收者不存在、机器人不在群中
+	permanent bool
+}
+
+// apiResult 开放平台接口的通用响应
+type apiResult struct {
+	Code int             `json:"code"`
+	Msg  string          `json:"msg"`
+	Data json.RawMessage `json:"data"`
+}
+
+// sendMessage makes one call to the send message api and classifies its result
+func sendMessage(receiveIdType feishuapi.MsgReceiverType, receiveId string, msgType feishuapi.MsgContentType, msg string) sendAttempt {
+	content := msg
+	if msgType == feishuapi.Text {
+		data, _ := json.Marshal(map[string]string{"text": msg})
+		content = string(data)
+	}
+	body, _ := json.Marshal(map[string]string{
+		"receive_id": receiveId,
+		"content":    content,
+		"msg_type":   string(msgType),
+	})
+
+	token, err := tenantAccessToken()
+	if err != nil {
+		return sendAttempt{err: err}
+	}
+	req, err := http.NewRequest(http.MethodPost, apiHost+messagesPath+"?receive_id_type="+url.QueryEscape(string(receiveIdType)), bytes.NewReader(body))
+	if err != nil {
+		return sendAttempt{err: err, permanent: true}
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+
+	resp, err := apiClient.Do(req)
+	if err != nil {
+		return sendAttempt{err: err}
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return sendAttempt{err: err}
+	}
+	var result apiResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		return sendAttempt{err: fmt.Errorf("http status %d, malformed response: %w", resp.StatusCode, err), permanent: resp.StatusCode < 500}
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusOK && result.Code == 0:
+		var sent struct {
+			MessageId string `json:"message_id"`
+		}
+		json.Unmarshal(result.Data, &sent)
+		return sendAttempt{messageId: sent.MessageId}
+	case resp.StatusCode == http.StatusTooManyRequests || result.Code == codeAppRateLimited || result.Code == codeChatRateLimited:
+		attempt := sendAttempt{err: apiError(resp.StatusCode, result), rateLimited: true}
+		if reset, err := strconv.Atoi(strings.TrimSpace(resp.Header.Get("x-ogw-ratelimit-reset"))); err == nil && reset > 0 {
+			attempt.retryAfter = time.Duration(reset) * time.Second
+		}
+		return attempt
+	case result.Code == codeTokenInvalid || result.Code == codeTokenMissing:
+		invalidateTenantToken()
+		return sendAttempt{err: apiError(resp.StatusCode, result)}
+	case resp.StatusCode >= 500:
+		return sendAttempt{err: apiError(resp.StatusCode, result)}
+	default:
+		return sendAttempt{err: apiError(resp.StatusCode, result), permanent: true}
+	}
+}
+
+func apiError(status int, result apiResult) error {
+	return fmt.Errorf("http status %d, code %d: %s", status, result.Code, result.Msg)
+}
+
+// tenantAccessToken returns the cached tenant_access_token, fetching a new one when it is about to expire
+func tenantAccessToken() (string, error) {
+	tenantToken.Lock()
+	defer tenantToken.Unlock()
+	if tenantToken.token != "" && time.Now().Before(tenantToken.expireAt.Add(-tokenRefreshMargin)) {
+		return tenantToken.token, nil
+	}
+
+	feishu := config.C().Feishu
+	body, _ := json.Marshal(map[string]string{"app_id": feishu.AppId, "app_secret": feishu.AppSecret})
+	ctx := context.WithValue(context.Background(), senderRequest{}, true)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, apiHost+tenantTokenPath, bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("get tenant_access_token: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	resp, err := apiClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("get tenant_access_token: %w", err)
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Code   int    `json:"code"`
+		Msg    string `json:"msg"`
+		Token  string `json:"tenant_access_token"`
+		Expire int    `json:"expire"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("get tenant_access_token: http status %d: %w", resp.StatusCode, err)
+	}
+	if result.Code != 0 || result.Token == "" {
+		return "", errors.New("get tenant_access_token: " + result.Msg)
+	}
+	tenantToken.token = result.Token
+	tenantToken.expireAt = time.Now().Add(time.Duration(result.Expire) * time.Second)
+	return tenantToken.token, nil
+}
+
+func invalidateTenantToken() {
+	tenantToken.Lock()
+	defer tenantToken.Unlock()
+	tenantToken.token = ""
+}
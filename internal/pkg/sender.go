@@ -0,0 +1,458 @@
+package pkg
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"math"
+	"sync"
+	"time"
+	"xlab-feishu-robot/internal/config"
+	"xlab-feishu-robot/internal/lock"
+	"xlab-feishu-robot/internal/log"
+	"xlab-feishu-robot/internal/metrics"
+	"xlab-feishu-robot/internal/store"
+
+	"github.com/YasyaKarasu/feishuapi"
+	"github.com/sirupsen/logrus"
+)
+
+// 未配置sender时的默认值，低于飞书的限制（每个应用每秒50条，同一会话每秒5条）
+const (
+	defaultSendRate     = 20
+	defaultChatSendRate = 4
+	defaultMaxAttempts  = 5
+	defaultRetryBackoff = time.Second
+	maxRetryBackoff     = 5 * time.Minute
+)
+
+// 每个进程把自己的发送队列保存在 send_queue-<实例id> 中，多个副本共用数据目录时互不覆盖。
+// 启动时接手已退出进程留下的队列，仍在运行的进程的队列由其自己发送
+const sendQueueStorePrefix = "send_queue"
+
+var sendQueueStore = sendQueueStorePrefix + "-" + lock.InstanceID()
+
+// queuedMessage 等待发送的消息，保存在store中
+type queuedMessage struct {
+	Id            string                    `json:"id"`
+	ReceiveIdType feishuapi.MsgReceiverType `json:"receive_id_type"`
+	ReceiveId     string                    `json:"receive_id"`
+	MsgType       feishuapi.MsgContentType  `json:"msg_type"`
+	Content       string                    `json:"content"`
+	Enqueued      time.Time                 `json:"enqueued"`
+	Attempts      int                       `json:"attempts"`
+	NextAttempt   time.Time                 `json:"next_attempt"`
+	// 入队时的日志关联id，发送时沿用
+	CorrelationId string `json:"correlation_id,omitempty"`
+}
+
+type sendResult struct {
+	messageId string
+	ok        bool
+}
+
+type outboundQueue struct {
+	sync.Mutex
+	messages []queuedMessage
+	// 等待发送结果的调用者，重启前入队的消息没有等待者
+	waiters map[string]chan sendResult
+	// StopSender之后为true，不再接受需要等待结果的消息
+	stopped bool
+	global  *tokenBucket
+	chats   map[string]*tokenBucket
+}
+
+var sendQueue = outboundQueue{
+	waiters: make(map[string]chan sendResult),
+	chats:   make(map[string]*tokenBucket),
+}
+
+var (
+	wakeSender = make(chan struct{}, 1)
+	stopSender = make(chan struct{})
+	senderDone = make(chan struct{})
+)
+
+// StartSender loads the messages left unsent before the last shutdown and starts sending the queue
+// in the background, within the rate limits from config
+func StartSender() {
+	c := config.C()
+	rate, burst := c.Sender.Rate, c.Sender.Burst
+	if rate <= 0 {
+		rate = defaultSendRate
+	}
+	if burst <= 0 {
+		burst = int(math.Ceil(rate))
+	}
+
+	// 进程运行期间一直持有自己的队列，其他进程不会接手
+	if _, _, err := store.Claim(sendQueueStore); err != nil {
+		logrus.Error("Failed to claim message queue: ", err)
+	}
+
+	sendQueue.Lock()
+	sendQueue.global = newTokenBucket(rate, burst)
+	adoptSendQueues()
+	pending := len(sendQueue.messages)
+	metrics.SendQueueLength.Set(float64(pending))
+	sendQueue.Unlock()
+
+	go sender()
+	logrus.WithFields(logrus.Fields{"rate": rate, "burst": burst, "unsent messages": pending}).Info("Message sender started")
+}
+
+// StopSender stops sending, messages still in the queue are kept in the store and sent after restart,
+// by this or another process sharing the data directory
+func StopSender(ctx context.Context) error {
+	close(stopSender)
+	select {
+	case <-senderDone:
+		sendQueue.Lock()
+		defer sendQueue.Unlock()
+		if len(sendQueue.messages) == 0 {
+			// 每次启动的实例id不同，不留下空的队列文件
+			if err := store.Remove(sendQueueStore); err != nil {
+				logrus.Error("Failed to remove empty message queue: ", err)
+			}
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// MessageSend queues the message and waits until it is delivered or given up, returns the message id.
+// After StopSender it fails at once without queueing the message.
+// The correlation id of ctx is stored with the message and logged when it is sent.
+// In dry-run mode the message is logged and kept in the outbox instead
+func MessageSend(ctx context.Context, receiveIdType feishuapi.MsgReceiverType, receiveId string, msgType feishuapi.MsgContentType, msg string) (string, bool) {
+	if config.C().DryRun() {
+		captureMessage(receiveIdType, receiveId, msgType, msg)
+		return "", true
+	}
+	result := <-enqueueMessage(ctx, receiveIdType, receiveId, msgType, msg, true)
+	return result.messageId, result.ok
+}
+
+// MessageEnqueue queues the message without waiting for it to be sent.
+// After StopSender the message is only stored, and sent after restart.
+// The correlation id of ctx is stored with the message and logged when it is sent.
+// In dry-run mode the message is logged and kept in the outbox instead
+func MessageEnqueue(ctx context.Context, receiveIdType feishuapi.MsgReceiverType, receiveId string, msgType feishuapi.MsgContentType, msg string) {
+	if config.C().DryRun() {
+		captureMessage(receiveIdType, receiveId, msgType, msg)
+		return
+	}
+	enqueueMessage(ctx, receiveIdType, receiveId, msgType, msg, false)
+}
+
+// enqueueMessage queues the message and returns the channel its result is sent to.
+// If wait is set and the sender has stopped, the message is not queued and the result is a failure
+func enqueueMessage(ctx context.Context, receiveIdType feishuapi.MsgReceiverType, receiveId string, msgType feishuapi.MsgContentType, msg string, wait bool) chan sendResult {
+	now := time.Now()
+	m := queuedMessage{
+		Id:            newMessageId(),
+		ReceiveIdType: receiveIdType,
+		ReceiveId:     receiveId,
+		MsgType:       msgType,
+		Content:       msg,
+		Enqueued:      now,
+		NextAttempt:   now,
+		CorrelationId: log.Correlation(ctx),
+	}
+	done := make(chan sendResult, 1)
+
+	sendQueue.Lock()
+	if sendQueue.stopped && wait {
+		sendQueue.Unlock()
+		logrus.WithContext(ctx).WithField("receive id", receiveId).Error("Message sender has stopped, message not sent")
+		done <- sendResult{}
+		return done
+	}
+	sendQueue.messages = append(sendQueue.messages, m)
+	if !sendQueue.stopped {
+		sendQueue.waiters[m.Id] = done
+	}
+	saveSendQueue()
+	sendQueue.Unlock()
+
+	select {
+	case wakeSender <- struct{}{}:
+	default:
+	}
+	return done
+}
+
+// sender sends the queue one message at a time, so that rate limits apply to all messages together
+func sender() {
+	defer close(senderDone)
+	for {
+		wait := sendNext()
+		timer := time.NewTimer(wait)
+		select {
+		case <-stopSender:
+			timer.Stop()
+			releaseWaiters()
+			return
+		case <-wakeSender:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// sendNext sends the first message which may be sent now, returns 0 if a message was sent,
+// otherwise how long to wait until a message could be sent.
+// Messages to the same chat are sent in order
+func sendNext() time.Duration {
+	now := time.Now()
+	wait := time.Duration(math.MaxInt64)
+
+	sendQueue.Lock()
+	index := -1
+	blocked := make(map[string]bool)
+	for i, m := range sendQueue.messages {
+		if blocked[m.ReceiveId] {
+			continue
+		}
+		blocked[m.ReceiveId] = true
+		if d := m.NextAttempt.Sub(now); d > 0 {
+			wait = minDuration(wait, d)
+			continue
+		}
+		if d := sendQueue.chatBucket(m.ReceiveId).wait(now); d > 0 {
+			wait = minDuration(wait, d)
+			continue
+		}
+		index = i
+		break
+	}
+	if index < 0 {
+		sendQueue.Unlock()
+		return wait
+	}
+	if d := sendQueue.global.wait(now); d > 0 {
+		sendQueue.Unlock()
+		return d
+	}
+	sendQueue.global.take(now)
+	sendQueue.chatBucket(sendQueue.messages[index].ReceiveId).take(now)
+	m := sendQueue.messages[index]
+	sendQueue.Unlock()
+
+	deliver(m)
+	return 0
+}
+
+// deliver makes one attempt to send the message, then removes it from the queue or schedules a retry.
+// Errors which cannot go away by retrying, such as an unknown receiver, are given up at once
+func deliver(m queuedMessage) {
+	attempt := sendMessage(m.ReceiveIdType, m.ReceiveId, m.MsgType, m.Content)
+	m.Attempts++
+
+	// 消息可能在重启后才发送，关联id随消息保存，不依赖入队时的context
+	entry := logrus.WithFields(logrus.Fields{"receive id": m.ReceiveId, "attempts": m.Attempts})
+	if m.CorrelationId != "" {
+		entry = entry.WithField(log.CorrelationField, m.CorrelationId)
+	}
+	if attempt.err != nil {
+		entry = entry.WithField("error", attempt.err)
+	}
+	sendQueue.Lock()
+	defer sendQueue.Unlock()
+	switch {
+	case attempt.err == nil:
+		metrics.MessagesSent.WithLabelValues(string(m.MsgType), "sent").Inc()
+		entry.WithField("message id", attempt.messageId).Info("Message sent")
+		sendQueue.finish(m.Id, sendResult{messageId: attempt.messageId, ok: true})
+	case attempt.permanent || m.Attempts >= maxAttempts():
+		metrics.MessagesSent.WithLabelValues(string(m.MsgType), "given_up").Inc()
+		entry.WithFields(logrus.Fields{"message": m.Content, "permanent": attempt.permanent}).Error("Failed to send message, giving up")
+		sendQueue.finish(m.Id, sendResult{})
+	default:
+		rateLimited, retryAfter := attempt.rateLimited, attempt.retryAfter
+		if rateLimited {
+			metrics.MessagesSent.WithLabelValues(string(m.MsgType), "rate_limited").Inc()
+		} else {
+			metrics.MessagesSent.WithLabelValues(string(m.MsgType), "retry").Inc()
+		}
+		backoff := retryBackoff(m.Attempts)
+		if rateLimited && retryAfter > backoff {
+			backoff = retryAfter
+		}
+		entry.WithFields(logrus.Fields{"rate limited": rateLimited, "retry in": backoff}).Warn("Failed to send message, will retry")
+		m.NextAttempt = time.Now().Add(backoff)
+		for i := range sendQueue.messages {
+			if sendQueue.messages[i].Id == m.Id {
+				sendQueue.messages[i] = m
+			}
+		}
+		saveSendQueue()
+	}
+}
+
+// releaseWaiters tells callers still waiting that their messages were not sent before shutdown,
+// and makes callers from now on fail at once
+func releaseWaiters() {
+	sendQueue.Lock()
+	defer sendQueue.Unlock()
+	sendQueue.stopped = true
+	for id, done := range sendQueue.waiters {
+		done <- sendResult{}
+		delete(sendQueue.waiters, id)
+	}
+}
+
+// finish removes the message from the queue and tells the waiting caller, if any, the result.
+// Must be called with the queue locked
+func (q *outboundQueue) finish(id string, result sendResult) {
+	for i := range q.messages {
+		if q.messages[i].Id == id {
+			q.messages = append(q.messages[:i], q.messages[i+1:]...)
+			break
+		}
+	}
+	if done, ok := q.waiters[id]; ok {
+		done <- result
+		delete(q.waiters, id)
+	}
+	saveSendQueue()
+}
+
+// chatBucket returns the token bucket of the chat, creating it on first use.
+// Must be called with the queue locked
+func (q *outboundQueue) chatBucket(receiveId string) *tokenBucket {
+	b, ok := q.chats[receiveId]
+	if !ok {
+		c := config.C()
+		rate, burst := c.Sender.ChatRate, c.Sender.ChatBurst
+		if rate <= 0 {
+			rate = defaultChatSendRate
+		}
+		if burst <= 0 {
+			burst = int(math.Ceil(rate))
+		}
+		b = newTokenBucket(rate, burst)
+		q.chats[receiveId] = b
+	}
+	return b
+}
+
+// adoptSendQueues moves the messages of queues left by processes which have exited into this process's queue.
+// Must be called with the queue locked
+func adoptSendQueues() {
+	names, err := store.Names(sendQueueStorePrefix)
+	if err != nil {
+		logrus.Error("Failed to list unsent message queues: ", err)
+		return
+	}
+	queued := make(map[string]bool)
+	for _, name := range names {
+		if name == sendQueueStore {
+			continue
+		}
+		release, ok, err := store.Claim(name)
+		if err != nil {
+			logrus.WithField("queue", name).Error("Failed to claim unsent message queue: ", err)
+			continue
+		}
+		if !ok {
+			// 队列所属的进程还在运行
+			continue
+		}
+		var messages []queuedMessage
+		if err := store.Load(name, &messages); err != nil {
+			logrus.WithField("queue", name).Error("Failed to load unsent messages: ", err)
+			release()
+			continue
+		}
+		for _, m := range messages {
+			if !queued[m.Id] {
+				queued[m.Id] = true
+				sendQueue.messages = append(sendQueue.messages, m)
+			}
+		}
+		// 先保存到自己的队列，再删除原队列，中途崩溃时消息不会丢失
+		saveSendQueue()
+		if err := store.Remove(name); err != nil {
+			logrus.WithField("queue", name).Error("Failed to remove adopted message queue: ", err)
+		}
+		release()
+		logrus.WithFields(logrus.Fields{"queue": name, "messages": len(messages)}).Info("Adopted unsent messages of a process which has exited")
+	}
+}
+
+// saveSendQueue persists the queue, must be called with the queue locked
+func saveSendQueue() {
+	metrics.SendQueueLength.Set(float64(len(sendQueue.messages)))
+	if err := store.Save(sendQueueStore, sendQueue.messages); err != nil {
+		logrus.Error("Failed to save unsent messages: ", err)
+	}
+}
+
+func maxAttempts() int {
+	c := config.C()
+	if c.Sender.MaxAttempts > 0 {
+		return c.Sender.MaxAttempts
+	}
+	return defaultMaxAttempts
+}
+
+// retryBackoff doubles the configured backoff after every failed attempt, up to maxRetryBackoff
+func retryBackoff(attempts int) time.Duration {
+	backoff := config.C().Sender.RetryBackoff
+	if backoff <= 0 {
+		backoff = defaultRetryBackoff
+	}
+	for i := 1; i < attempts && backoff < maxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	return minDuration(backoff, maxRetryBackoff)
+}
+
+func minDuration(a, b time.Duration) time.Duration {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func newMessageId() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// tokenBucket 令牌桶，每秒补充rate个令牌，最多存burst个
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if now.After(b.last) {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+	}
+}
+
+// wait returns how long until a token is available, 0 if one is available now
+func (b *tokenBucket) wait(now time.Time) time.Duration {
+	b.refill(now)
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// take uses one token, the caller must have checked wait
+func (b *tokenBucket) take(now time.Time) {
+	b.refill(now)
+	b.tokens--
+}
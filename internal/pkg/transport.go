@@ -0,0 +1,116 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"xlab-feishu-robot/internal/metrics"
+)
+
+// 获取tenant_access_token接口的路径
+const tenantTokenPath = "/open-apis/auth/v3/tenant_access_token/internal"
+
+// tokenRefreshed pkg.Cli最近一次成功获取tenant_access_token的时间（unix纳秒），不包括发送队列自己获取的token
+var tokenRefreshed atomic.Int64
+
+// feishuapi 只返回请求是否成功，无法得知失败原因，
+// 因此在默认的Transport上观察飞书开放平台的响应：记录每个接口的延迟和结果，以及token的刷新时间
+type feishuTransport struct {
+	base http.RoundTripper
+}
+
+// InstallTransport wraps http.DefaultTransport. feishuapi creates a new http.Client without a Transport
+// for every request and offers no way to set one, so the default transport is the only place to observe its calls.
+// Only requests to /open-apis/ are instrumented, others (such as the long connection's) pass through unchanged
+func InstallTransport() {
+	http.DefaultTransport = &feishuTransport{base: http.DefaultTransport}
+}
+
+func (t *feishuTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !strings.HasPrefix(req.URL.Path, "/open-apis/") {
+		return t.base.RoundTrip(req)
+	}
+
+	endpoint := apiEndpoint(req.URL.Path)
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	metrics.APIDuration.WithLabelValues(req.Method, endpoint).Observe(time.Since(start).Seconds())
+	if err != nil {
+		metrics.APIRequests.WithLabelValues(req.Method, endpoint, "network_error").Inc()
+		return resp, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		metrics.APIRequests.WithLabelValues(req.Method, endpoint, "network_error").Inc()
+		return resp, nil
+	}
+	result := struct {
+		Code int `json:"code"`
+	}{Code: -1}
+	json.Unmarshal(body, &result)
+
+	switch {
+	case resp.StatusCode != http.StatusOK:
+		metrics.APIRequests.WithLabelValues(req.Method, endpoint, "http_error").Inc()
+	case result.Code != 0:
+		metrics.APIRequests.WithLabelValues(req.Method, endpoint, "api_error").Inc()
+	default:
+		metrics.APIRequests.WithLabelValues(req.Method, endpoint, "ok").Inc()
+	}
+
+	fromSender := req.Context().Value(senderRequest{}) != nil
+	if req.URL.Path == tenantTokenPath && !fromSender && resp.StatusCode == http.StatusOK && result.Code == 0 {
+		tokenRefreshed.Store(time.Now().UnixNano())
+	}
+
+	return resp, nil
+}
+
+// TokenRefreshedAt returns when the tenant access token of pkg.Cli was last fetched successfully,
+// by SetupFeishuApiClient or StartTokenTimer, or the zero time if it never was.
+// The token the message sender fetches for itself does not count, so a failing StartTokenTimer is not hidden by it
+func TokenRefreshedAt() time.Time {
+	if nanos := tokenRefreshed.Load(); nanos != 0 {
+		return time.Unix(0, nanos)
+	}
+	return time.Time{}
+}
+
+// apiEndpoint replaces the ids and tokens in the path with ":id", so that the metrics have a bounded number of endpoints,
+// e.g. /open-apis/bitable/v1/apps/:id/tables/:id/records
+func apiEndpoint(path string) string {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for i, segment := range segments {
+		if !isPathWord(segment) {
+			segments[i] = ":id"
+		}
+	}
+	return "/" + strings.Join(segments, "/")
+}
+
+// isPathWord reports whether the path segment is part of the api name rather than an id:
+// lower case words joined by "-" or "_", or a version such as v1
+func isPathWord(segment string) bool {
+	if segment == "" {
+		return false
+	}
+	if len(segment) >= 2 && segment[0] == 'v' {
+		if _, err := strconv.Atoi(segment[1:]); err == nil {
+			return true
+		}
+	}
+	for _, r := range segment {
+		if (r < 'a' || r > 'z') && r != '-' && r != '_' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package store
+
+// lockFile 文件锁只在unix系统上可用，其他系统上只有进程内的互斥
+func lockFile(name string, exclusive bool) (func(), error) {
+	return func() {}, nil
+}
+
+// Claim 其他系统上无法判断其他进程是否在使用，总是认为可以使用
+func Claim(name string) (release func(), ok bool, err error) {
+	return func() {}, true, nil
+}
@@ -0,0 +1,58 @@
+//go:build unix
+
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// lockFile takes a flock on the lock file of name, exclusive for writing and shared for reading,
+// and returns the function releasing it
+func lockFile(name string, exclusive bool) (func(), error) {
+	p := path(name) + ".lock"
+	if err := os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(p, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	if err := syscall.Flock(int(file.Fd()), how); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return func() {
+		syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		file.Close()
+	}, nil
+}
+
+// Claim marks the value stored under name as owned by this process until it exits or calls release.
+// It reports false if another running process owns it
+func Claim(name string) (release func(), ok bool, err error) {
+	p := path(name) + ".owner"
+	if err := os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+		return nil, false, err
+	}
+	file, err := os.OpenFile(p, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	return func() {
+		syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		file.Close()
+	}, true, nil
+}
@@ -0,0 +1,143 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"xlab-feishu-robot/internal/config"
+)
+
+// 简单的本地持久化存储：每个name对应数据目录下的一个JSON文件
+// 多个副本共用同一个数据目录时，需要共享的状态应在使用时用Load读取，用Update修改，不要只在启动时读取一次
+// 读写时除了进程内的mu，还会对name.json.lock加文件锁，使其他进程的Update也不会穿插进来
+
+var mu sync.Mutex
+
+func path(name string) string {
+	dir := config.C().Store.Dir
+	if dir == "" {
+		dir = "./data"
+	}
+	return filepath.Join(dir, name+".json")
+}
+
+// Load reads the value stored under name into v.
+// If nothing has been stored yet, v is left untouched and no error is returned
+func Load(name string, v any) error {
+	mu.Lock()
+	defer mu.Unlock()
+	unlock, err := lockFile(name, false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return load(name, v)
+}
+
+// Save stores v under name, replacing the previous value.
+// The value is written to a temporary file first, so a crash never leaves a half-written value
+func Save(name string, v any) error {
+	mu.Lock()
+	defer mu.Unlock()
+	unlock, err := lockFile(name, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return save(name, v)
+}
+
+// Update reads the value stored under name into v, lets modify change it and stores it again,
+// without another Save or Update in between, also from other processes sharing the data directory.
+// Nothing is stored if modify returns false
+func Update(name string, v any, modify func() bool) error {
+	mu.Lock()
+	defer mu.Unlock()
+	unlock, err := lockFile(name, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := load(name, v); err != nil {
+		return err
+	}
+	if !modify() {
+		return nil
+	}
+	return save(name, v)
+}
+
+// Names returns the names of the stored values starting with prefix
+func Names(prefix string) ([]string, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	matches, err := filepath.Glob(path(prefix + "*"))
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(matches))
+	for _, match := range matches {
+		names = append(names, strings.TrimSuffix(filepath.Base(match), ".json"))
+	}
+	return names, nil
+}
+
+// Remove deletes the value stored under name, together with its lock files
+func Remove(name string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	unlock, err := lockFile(name, true)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path(name))
+	unlock()
+	os.Remove(path(name) + ".lock")
+	os.Remove(path(name) + ".owner")
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func load(name string, v any) error {
+	data, err := os.ReadFile(path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func save(name string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	p := path(name)
+	if err := os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+		return err
+	}
+	// 临时文件名唯一，不会与其他进程的写入冲突
+	tmp, err := os.CreateTemp(filepath.Dir(p), filepath.Base(p)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
@@ -1,10 +1,19 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 	"xlab-feishu-robot/docs"
 	config "xlab-feishu-robot/internal/config"
 	"xlab-feishu-robot/internal/controller"
+	"xlab-feishu-robot/internal/dispatcher"
 	"xlab-feishu-robot/internal/log"
 
 	"xlab-feishu-robot/internal/pkg"
@@ -17,21 +26,37 @@ import (
 	ginSwagger "github.com/swaggo/gin-swagger"
 )
 
+// @securityDefinitions.apikey AdminToken
+// @in header
+// @name Authorization
+// @description Bearer token configured by admin.token
 func main() {
-	config.ReadConfig()
+	configPath := flag.String("config", config.DefaultPath, "path of the config file")
+	flag.Parse()
+	config.ReadConfig(*configPath)
+	c := config.C()
 
 	// log
 	log.SetupLogrus()
 	logrus.Info("Robot starts up")
 
 	// feishu api client
+	pkg.InstallTransport()
 	config.SetupFeishuApiClient(&pkg.Cli)
 	pkg.Cli.StartTokenTimer()
+	pkg.StartSender()
 
 	// robot server
 	r := gin.Default()
 	internal.Init(r)
 
+	// feishu event listeners and group commands
+	controller.InitEvent()
+	dispatcher.StartWorkers()
+	if c.LongConnection() {
+		dispatcher.StartLongConnection()
+	}
+
 	// api docs by swagger
 	docs.SwaggerInfo.BasePath = "/"
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerfiles.Handler))
@@ -39,5 +64,60 @@ func main() {
 	// Start reminder
 	controller.Remind()
 
-	r.Run(":" + fmt.Sprint(config.C.Server.Port))
+	// apply changes of the config file without restart
+	config.WatchConfig()
+
+	srv := &http.Server{
+		Addr:    ":" + fmt.Sprint(c.Server.Port),
+		Handler: r,
+	}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logrus.Fatal("Failed to start server: ", err)
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+	shutdown(srv)
+}
+
+// shutdown stops accepting events, then waits for running cron jobs and event handlers and stops sending messages,
+// all within server.shutdownTimeout
+func shutdown(srv *http.Server) {
+	timeout := config.C().Server.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	logrus.Info("Robot shuts down, timeout: ", timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		logrus.Error("Failed to shut down server: ", err)
+	} else {
+		logrus.Info("Server stopped accepting events")
+	}
+	// 长连接没有可以等待的关闭过程，停止接收后到达的事件会被拒绝，由飞书稍后重新推送
+	dispatcher.StopIntake()
+
+	if err := controller.Stop(ctx); err != nil {
+		logrus.Error("Failed to stop cron timer: ", err)
+	}
+
+	logrus.Info("Waiting for running event handlers")
+	if err := dispatcher.Wait(ctx); err != nil {
+		logrus.Error("Timeout waiting for running event handlers: ", err)
+	} else {
+		logrus.Info("All event handlers finished")
+	}
+
+	if err := pkg.StopSender(ctx); err != nil {
+		logrus.Error("Timeout stopping message sender: ", err)
+	} else {
+		logrus.Info("Message sender stopped, unsent messages are kept for the next start")
+	}
+
+	logrus.Info("Robot stopped")
 }